@@ -3,6 +3,7 @@ package mp
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"fmt"
 	"time"
 )
 
@@ -293,3 +294,34 @@ func (c *Client) GetAgentMsgRecords(timeSpan *TimeSpan, pageIndex int, pageSize
 	records = rep.Records
 	return
 }
+
+type MsgRecordIterator struct {
+	pager
+	items []MsgRecord
+}
+
+// IterateAgentMsgRecords iterates the message records in timeSpan with pageSize(no more than 50) records per request,
+// 50 if pageSize is 0.
+func (c *Client) IterateAgentMsgRecords(timeSpan *TimeSpan, pageSize int) *MsgRecordIterator {
+	if pageSize <= 0 {
+		pageSize = 50
+	}
+
+	it := &MsgRecordIterator{}
+	it.pager = newPager(pageSize, func(offset, count int) (int, bool, error) {
+		if count > 50 {
+			return 0, false, fmt.Errorf("invalid agent message records page size: %d", count)
+		}
+		records, err := c.GetAgentMsgRecords(timeSpan, offset/count+1, count)
+		if err != nil {
+			return 0, false, err
+		}
+		it.items = records
+		return len(records), len(records) == count, nil
+	})
+	return it
+}
+
+func (it *MsgRecordIterator) Value() *MsgRecord {
+	return &it.items[it.index]
+}
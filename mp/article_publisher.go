@@ -0,0 +1,446 @@
+package mp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+	"github.com/russross/blackfriday"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// DefaultArticleStyles are the inline styles applied to the elements of article content,
+// since WeChat removes the style sheets and class attributes.
+var DefaultArticleStyles = map[string]string{
+	"p":          "margin: 0 0 1em; line-height: 1.75; font-size: 15px; color: #333;",
+	"h1":         "margin: 1.2em 0 0.8em; font-size: 22px; font-weight: bold; color: #222;",
+	"h2":         "margin: 1.2em 0 0.8em; font-size: 20px; font-weight: bold; color: #222;",
+	"h3":         "margin: 1em 0 0.6em; font-size: 18px; font-weight: bold; color: #222;",
+	"h4":         "margin: 1em 0 0.6em; font-size: 16px; font-weight: bold; color: #222;",
+	"h5":         "margin: 1em 0 0.6em; font-size: 15px; font-weight: bold; color: #222;",
+	"h6":         "margin: 1em 0 0.6em; font-size: 15px; font-weight: bold; color: #666;",
+	"blockquote": "margin: 1em 0; padding: 0.5em 1em; border-left: 4px solid #ddd; background: #f7f7f7; color: #666;",
+	"pre":        "margin: 1em 0; padding: 1em; overflow-x: auto; background: #f6f8fa; border-radius: 4px; font-size: 13px; line-height: 1.5;",
+	"code":       "font-family: Menlo, Consolas, monospace; font-size: 90%; background: #f6f8fa; padding: 2px 4px; border-radius: 3px;",
+	"ul":         "margin: 0 0 1em; padding-left: 2em;",
+	"ol":         "margin: 0 0 1em; padding-left: 2em;",
+	"li":         "margin: 0.25em 0; line-height: 1.75;",
+	"img":        "display: block; max-width: 100%; height: auto; margin: 1em auto;",
+	"a":          "color: #576b95; text-decoration: none;",
+	"table":      "border-collapse: collapse; width: 100%; margin: 1em 0;",
+	"th":         "border: 1px solid #ddd; padding: 6px 10px; background: #f6f8fa;",
+	"td":         "border: 1px solid #ddd; padding: 6px 10px;",
+	"hr":         "border: none; border-top: 1px solid #eee; margin: 1.5em 0;",
+	"strong":     "font-weight: bold;",
+}
+
+// elements removed with their contents
+var forbiddenArticleElements = map[string]bool{
+	"script": true, "style": true, "link": true, "meta": true, "noscript": true,
+	"iframe": true, "frame": true, "frameset": true, "object": true, "embed": true,
+	"form": true, "input": true, "button": true, "textarea": true, "select": true,
+}
+
+// schemes of the links allowed in article content, besides the relative links
+var allowedArticleSchemes = map[string]bool{
+	"http": true, "https": true, "mailto": true,
+}
+
+var allowedArticleAttrs = map[string]bool{
+	"style": true, "href": true, "src": true, "alt": true, "title": true,
+	"colspan": true, "rowspan": true, "align": true,
+}
+
+// ArticleSource is the source of an article in Markdown or HTML.
+type ArticleSource struct {
+	Title            string `json:"title"`
+	Author           string `json:"author,omitempty"`
+	Digest           string `json:"digest,omitempty"`
+	ContentSourceURL string `json:"content_source_url,omitempty"`
+	Thumb            string `json:"thumb"` // local path or URL of the cover picture
+	ShowCoverPic     bool   `json:"show_cover_pic,omitempty"`
+
+	Content  []byte `json:"-"`
+	Markdown bool   `json:"-"`
+	BaseDir  string `json:"-"` // directory of the relative paths of local images and thumb
+}
+
+// LoadArticleSource loads the article from a Markdown(.md, .markdown) or HTML file,
+// with the other fields of ArticleSource in the optional YAML front matter, e.g.
+//
+//	---
+//	title: Hello
+//	author: WeChat
+//	thumb: images/cover.jpg
+//	---
+//	# Hello
+func LoadArticleSource(filePath string) (*ArticleSource, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var src ArticleSource
+
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if bytes.HasPrefix(data, []byte("---\n")) || bytes.HasPrefix(data, []byte("---\r\n")) {
+		rest := data[bytes.IndexByte(data, '\n')+1:]
+		end := bytes.Index(rest, []byte("\n---"))
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated front matter: %s", filePath)
+		}
+		if err = yaml.Unmarshal(rest[:end], &src); err != nil {
+			return nil, fmt.Errorf("invalid front matter: %s: %v", filePath, err)
+		}
+		rest = rest[end+len("\n---"):]
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			rest = rest[i+1:]
+		} else {
+			rest = nil
+		}
+		data = rest
+	}
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".md", ".markdown":
+		src.Markdown = true
+	}
+	src.Content = data
+	src.BaseDir = filepath.Dir(filePath)
+	return &src, nil
+}
+
+// ArticlePublisher converts Markdown or HTML into WeChat-compatible HTML, uploads the images
+// referenced by it, and creates the news.
+type ArticlePublisher struct {
+	client *Client
+
+	Styles     map[string]string // inline styles by element name, default DefaultArticleStyles
+	HTTPClient *http.Client      // for downloading remote images, default http.DefaultClient
+	MediaCache *MediaCache       // if not nil, used to upload thumbs
+
+	imagesMutex sync.Mutex
+	images      map[string]string // uploaded image URLs by source
+}
+
+func NewArticlePublisher(client *Client) *ArticlePublisher {
+	return &ArticlePublisher{
+		client:     client,
+		Styles:     DefaultArticleStyles,
+		HTTPClient: http.DefaultClient,
+		images:     make(map[string]string),
+	}
+}
+
+// ConvertMarkdown converts the Markdown into HTML by blackfriday v1, and then calls ConvertHTML.
+func (p *ArticlePublisher) ConvertMarkdown(content []byte, baseDir string) (string, error) {
+	return p.ConvertHTML(blackfriday.MarkdownCommon(content), baseDir)
+}
+
+// ConvertHTML removes the elements and attributes not allowed by WeChat, inlines the styles,
+// and replaces the images with the uploaded ones.
+func (p *ArticlePublisher) ConvertHTML(content []byte, baseDir string) (string, error) {
+	container := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "section",
+		DataAtom: atom.Section,
+	}
+
+	nodes, err := html.ParseFragment(bytes.NewReader(content), container)
+	if err != nil {
+		return "", err
+	}
+	for _, node := range nodes {
+		container.AppendChild(node)
+	}
+
+	if err = p.convertChildren(container, baseDir); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	for node := container.FirstChild; node != nil; node = node.NextSibling {
+		if err = html.Render(&buf, node); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+func (p *ArticlePublisher) convertChildren(parent *html.Node, baseDir string) error {
+	for node := parent.FirstChild; node != nil; {
+		next := node.NextSibling
+
+		switch node.Type {
+		case html.CommentNode, html.DoctypeNode:
+			parent.RemoveChild(node)
+		case html.ElementNode:
+			if forbiddenArticleElements[node.Data] {
+				parent.RemoveChild(node)
+				break
+			}
+			if err := p.convertElement(node, baseDir); err != nil {
+				return err
+			}
+			if err := p.convertChildren(node, baseDir); err != nil {
+				return err
+			}
+		}
+
+		node = next
+	}
+	return nil
+}
+
+func (p *ArticlePublisher) convertElement(node *html.Node, baseDir string) error {
+	attrs := node.Attr[:0]
+	style := p.Styles[node.Data]
+	for _, attr := range node.Attr {
+		if attr.Namespace != "" || !allowedArticleAttrs[attr.Key] {
+			continue
+		}
+		switch attr.Key {
+		case "style":
+			style = strings.TrimSpace(style + " " + attr.Val)
+			continue
+		case "href":
+			if !isSafeArticleHref(attr.Val) {
+				continue
+			}
+		case "src":
+			if node.DataAtom != atom.Img {
+				continue
+			}
+			u, err := p.uploadImage(attr.Val, baseDir)
+			if err != nil {
+				return err
+			}
+			attr.Val = u
+		}
+		attrs = append(attrs, attr)
+	}
+	if style != "" {
+		attrs = append(attrs, html.Attribute{Key: "style", Val: style})
+	}
+	node.Attr = attrs
+	return nil
+}
+
+// isSafeArticleHref reports whether href is a relative link or a link of allowedArticleSchemes.
+// The entities of href have been unescaped by the HTML parser, and the tabs and newlines
+// are removed as browsers do, so "java&#9;script:" is not allowed either.
+func isSafeArticleHref(href string) bool {
+	href = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, href)
+	href = strings.TrimFunc(href, func(r rune) bool {
+		return r <= ' '
+	})
+	for _, r := range href {
+		if r < ' ' || r == 0x7f {
+			return false
+		}
+	}
+
+	u, err := url.Parse(href)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" || allowedArticleSchemes[strings.ToLower(u.Scheme)]
+}
+
+func isWechatImageURL(src string) bool {
+	u, err := url.Parse(src)
+	if err != nil {
+		return false
+	}
+	return u.Host == "mmbiz.qpic.cn" || strings.HasSuffix(u.Host, ".qpic.cn")
+}
+
+func (p *ArticlePublisher) uploadImage(src, baseDir string) (string, error) {
+	if isWechatImageURL(src) {
+		return src, nil
+	}
+
+	key := src
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		key = baseDir + "\x00" + src
+	}
+	p.imagesMutex.Lock()
+	u, ok := p.images[key]
+	p.imagesMutex.Unlock()
+	if ok {
+		return u, nil
+	}
+
+	reader, fileName, contentType, err := p.openImage(src, baseDir)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	u, err = p.client.UploadArticleImageFromReader(reader, fileName, contentType)
+	if err != nil {
+		return "", fmt.Errorf("upload image %s failed: %v", src, err)
+	}
+
+	p.imagesMutex.Lock()
+	if p.images == nil {
+		p.images = make(map[string]string)
+	}
+	p.images[key] = u
+	p.imagesMutex.Unlock()
+	return u, nil
+}
+
+// openImage opens the image of a URL, or a local path relative to baseDir.
+func (p *ArticlePublisher) openImage(src, baseDir string) (reader io.ReadCloser, fileName, contentType string, err error) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		client := p.HTTPClient
+		if client == nil {
+			client = http.DefaultClient
+		}
+
+		var rep *http.Response
+		rep, err = client.Get(src)
+		if err != nil {
+			return
+		}
+		if rep.StatusCode != http.StatusOK {
+			rep.Body.Close()
+			err = fmt.Errorf("download image %s failed: http.Status: %s", src, rep.Status)
+			return
+		}
+		return rep.Body, path.Base(u.Path), rep.Header.Get("Content-Type"), nil
+
+	case "", "file":
+		filePath := filepath.FromSlash(u.Path)
+		if !filepath.IsAbs(filePath) {
+			filePath = filepath.Join(baseDir, filePath)
+		}
+
+		var file *os.File
+		file, err = os.Open(filePath)
+		if err != nil {
+			return
+		}
+		return file, filepath.Base(filePath), "", nil
+
+	default:
+		err = fmt.Errorf("unsupported image source: %s", src)
+		return
+	}
+}
+
+func (p *ArticlePublisher) uploadThumb(thumb, baseDir string) (string, error) {
+	reader, fileName, contentType, err := p.openImage(thumb, baseDir)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	if p.MediaCache != nil {
+		media, err := p.MediaCache.UploadMediaFromReader(MediaImage, reader, fileName, contentType)
+		if err != nil {
+			return "", err
+		}
+		return media.MediaID, nil
+	}
+
+	media, err := p.client.UploadMediaFromReader(MediaImage, reader, fileName, contentType)
+	if err != nil {
+		return "", err
+	}
+	return media.Id, nil
+}
+
+// BuildArticle converts the content and uploads the images and thumb of the source.
+func (p *ArticlePublisher) BuildArticle(src *ArticleSource) (*Article, error) {
+	if src.Title == "" {
+		return nil, fmt.Errorf("article title is empty")
+	}
+	if src.Thumb == "" {
+		return nil, fmt.Errorf("article thumb is empty: %s", src.Title)
+	}
+
+	var content string
+	var err error
+	if src.Markdown {
+		content, err = p.ConvertMarkdown(src.Content, src.BaseDir)
+	} else {
+		content, err = p.ConvertHTML(src.Content, src.BaseDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	thumbID, err := p.uploadThumb(src.Thumb, src.BaseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	article := &Article{
+		ThumbId:          thumbID,
+		Title:            src.Title,
+		Author:           src.Author,
+		Digest:           src.Digest,
+		Content:          content,
+		ContentSourceURL: src.ContentSourceURL,
+	}
+	if src.ShowCoverPic {
+		article.ShowCoverPic = 1
+	}
+	return article, nil
+}
+
+func (p *ArticlePublisher) buildArticles(sources []*ArticleSource) ([]Article, error) {
+	articles := make([]Article, 0, len(sources))
+	for _, src := range sources {
+		article, err := p.BuildArticle(src)
+		if err != nil {
+			return nil, err
+		}
+		articles = append(articles, *article)
+	}
+	return articles, nil
+}
+
+// Publish creates the permanent news of the articles, and returns its media id.
+func (p *ArticlePublisher) Publish(sources ...*ArticleSource) (mediaId string, err error) {
+	articles, err := p.buildArticles(sources)
+	if err != nil {
+		return "", err
+	}
+
+	return p.client.CreateNews(&News{Articles: articles})
+}
+
+// AddDraft adds the articles into the draft box, and returns the media id of the draft,
+// which can be published by Client.SubmitPublish or Client.PublishAndWait.
+func (p *ArticlePublisher) AddDraft(sources ...*ArticleSource) (mediaId string, err error) {
+	articles, err := p.buildArticles(sources)
+	if err != nil {
+		return "", err
+	}
+
+	return p.client.AddDraft(articles)
+}
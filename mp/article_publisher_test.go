@@ -0,0 +1,259 @@
+package mp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIsSafeArticleHref(t *testing.T) {
+	tests := []struct {
+		href string
+		want bool
+	}{
+		{"http://example.com/", true},
+		{"https://example.com/a?b=c", true},
+		{"HTTPS://example.com/", true},
+		{"mailto:a@example.com", true},
+		{"/path/to/page", true},
+		{"page.html#top", true},
+		{"#top", true},
+		{"//example.com/", true},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(1)", false},
+		{"  javascript:alert(1)", false},
+		{"java\tscript:alert(1)", false},
+		{"java\nscript:alert(1)", false},
+		{"\x01javascript:alert(1)", false},
+		{"java\x00script:alert(1)", false},
+		{"vbscript:msgbox(1)", false},
+		{"data:text/html;base64,PHNjcmlwdD4=", false},
+		{"file:///etc/passwd", false},
+	}
+	for _, tt := range tests {
+		if got := isSafeArticleHref(tt.href); got != tt.want {
+			t.Errorf("isSafeArticleHref(%q) = %v, want %v", tt.href, got, tt.want)
+		}
+	}
+}
+
+func TestConvertHTML(t *testing.T) {
+	p := NewArticlePublisher(nil)
+	p.Styles = map[string]string{"p": "color: red;"}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "inline styles",
+			content: `<p class="x" style="font-size: 12px;">text</p>`,
+			want:    `<p style="color: red; font-size: 12px;">text</p>`,
+		},
+		{
+			name:    "forbidden elements",
+			content: `<div><script>alert(1)</script><style>p{}</style>text<iframe src="x"></iframe></div>`,
+			want:    `<div>text</div>`,
+		},
+		{
+			name:    "comments and attributes",
+			content: `<!-- comment --><a href="https://example.com/" onclick="alert(1)" title="t">link</a>`,
+			want:    `<a href="https://example.com/" title="t">link</a>`,
+		},
+		{
+			name:    "unsafe links",
+			content: `<a href="javascript:alert(1)">a</a><a href="java&#x09;script:alert(1)">b</a><a href="&#x20;vbscript:x">c</a><a href="data:text/html,x">d</a>`,
+			want:    `<a>a</a><a>b</a><a>c</a><a>d</a>`,
+		},
+		{
+			name:    "src of non-image",
+			content: `<video src="x.mp4"></video>`,
+			want:    `<video></video>`,
+		},
+		{
+			name:    "wechat images",
+			content: `<img src="https://mmbiz.qpic.cn/a.jpg" alt="a">`,
+			want:    `<img src="https://mmbiz.qpic.cn/a.jpg" alt="a"/>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.ConvertHTML([]byte(tt.content), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertHTMLImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "article-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "a.png"), []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var uploads int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cgi-bin/media/uploadimg" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		n := atomic.AddInt32(&uploads, 1)
+		w.Write([]byte(`{"url":"https://mmbiz.qpic.cn/` + string(rune('0'+n)) + `.png"}`))
+	})
+	p := NewArticlePublisher(c)
+	p.Styles = nil
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		uploads int32 // total uploads after this case
+	}{
+		{
+			name:    "local image uploaded",
+			content: `<img src="a.png">`,
+			want:    `<img src="https://mmbiz.qpic.cn/1.png"/>`,
+			uploads: 1,
+		},
+		{
+			name:    "uploaded image memoized",
+			content: `<p><img src="a.png"><img src="a.png"></p>`,
+			want:    `<p><img src="https://mmbiz.qpic.cn/1.png"/><img src="https://mmbiz.qpic.cn/1.png"/></p>`,
+			uploads: 1,
+		},
+		{
+			name:    "wechat image not uploaded",
+			content: `<img src="https://mmbiz.qpic.cn/x.png">`,
+			want:    `<img src="https://mmbiz.qpic.cn/x.png"/>`,
+			uploads: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.ConvertHTML([]byte(tt.content), dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if n := atomic.LoadInt32(&uploads); n != tt.uploads {
+				t.Errorf("uploaded %d times, want %d", n, tt.uploads)
+			}
+		})
+	}
+
+	if _, err = p.ConvertHTML([]byte(`<img src="missing.png">`), dir); err == nil {
+		t.Error("got nil, want error of missing image")
+	}
+
+	// concurrent conversions share the memo
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.ConvertHTML([]byte(`<img src="a.png">`), dir); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestLoadArticleSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "article-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		want     ArticleSource // BaseDir is dir
+		hasError bool
+	}{
+		{
+			name:    "markdown with front matter",
+			file:    "a.md",
+			content: "---\ntitle: Hello\nauthor: WeChat\nthumb: images/cover.jpg\nshow_cover_pic: true\n---\n# Hello\n",
+			want: ArticleSource{
+				Title:        "Hello",
+				Author:       "WeChat",
+				Thumb:        "images/cover.jpg",
+				ShowCoverPic: true,
+				Content:      []byte("# Hello\n"),
+				Markdown:     true,
+			},
+		},
+		{
+			name:    "crlf and bom",
+			file:    "b.markdown",
+			content: "\xef\xbb\xbf---\r\ntitle: Hello\r\n---\r\ntext",
+			want: ArticleSource{
+				Title:    "Hello",
+				Content:  []byte("text"),
+				Markdown: true,
+			},
+		},
+		{
+			name:    "html without front matter",
+			file:    "c.html",
+			content: "<p>text</p>",
+			want:    ArticleSource{Content: []byte("<p>text</p>")},
+		},
+		{
+			name:     "unterminated front matter",
+			file:     "d.md",
+			content:  "---\ntitle: Hello\n# Hello\n",
+			hasError: true,
+		},
+		{
+			name:     "invalid front matter",
+			file:     "e.md",
+			content:  "---\ntitle: [Hello\n---\n",
+			hasError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(dir, tt.file)
+			if err := ioutil.WriteFile(filePath, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			src, err := LoadArticleSource(filePath)
+			if tt.hasError {
+				if err == nil {
+					t.Fatalf("got %+v, want error", src)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := tt.want
+			want.BaseDir = dir
+			if src.Title != want.Title || src.Author != want.Author || src.Thumb != want.Thumb ||
+				src.ShowCoverPic != want.ShowCoverPic || src.Markdown != want.Markdown || src.BaseDir != want.BaseDir ||
+				string(src.Content) != string(want.Content) {
+				t.Errorf("got %+v, want %+v", src, want)
+			}
+		})
+	}
+}
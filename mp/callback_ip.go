@@ -0,0 +1,243 @@
+package mp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jiudaoyun/wechat"
+	"github.com/ridewindx/mel"
+	"go.uber.org/zap"
+)
+
+// CallbackIPVerifier verifies that the requests come from the callback IPs of Wechat, got by GetCallbackIP.
+// It is a defense in depth besides the signature. The IP list is cached, and refreshed in background
+// when older than RefreshInterval.
+type CallbackIPVerifier struct {
+	client *Client
+	logger *zap.SugaredLogger
+
+	RefreshInterval time.Duration // default 1 hour
+	FailOpen        bool          // whether to allow the requests if the IP list has never been got
+
+	trustedProxies []*net.IPNet
+
+	mutex       sync.RWMutex
+	nets        []*net.IPNet
+	refreshedAt time.Time
+	failedAt    time.Time // when getting the list failed last time
+	refreshing  bool
+	fetching    chan struct{} // closed when the first fetch in flight is done
+}
+
+func NewCallbackIPVerifier(client *Client) *CallbackIPVerifier {
+	return &CallbackIPVerifier{
+		client:          client,
+		logger:          wechat.Sugar,
+		RefreshInterval: time.Hour,
+	}
+}
+
+// SetTrustedProxies sets the IPs or CIDRs of the reverse proxies in front of the server,
+// whose X-Forwarded-For headers are trusted. It should be called before using the verifier.
+func (v *CallbackIPVerifier) SetTrustedProxies(proxies ...string) error {
+	nets, err := parseIPNets(proxies)
+	if err != nil {
+		return err
+	}
+	v.trustedProxies = nets
+	return nil
+}
+
+// parseIPNets parses the IPs or CIDRs, e.g. "101.226.62.77" or "101.226.103.0/25".
+func parseIPNets(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if strings.Contains(s, "/") {
+			_, n, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, err
+			}
+			nets = append(nets, n)
+			continue
+		}
+
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP: %s", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Refresh gets the callback IP list from Wechat.
+func (v *CallbackIPVerifier) Refresh() error {
+	ipList, err := v.client.GetCallbackIP()
+	if err != nil {
+		return err
+	}
+	nets, err := parseIPNets(ipList)
+	if err != nil {
+		return err
+	}
+
+	v.mutex.Lock()
+	v.nets = nets
+	v.refreshedAt = time.Now()
+	v.mutex.Unlock()
+	return nil
+}
+
+// callbackNets returns the cached IP list, which is got synchronously for the first time,
+// and refreshed in background when stale. The stale list is kept if refreshing fails.
+// The concurrent requests without the list wait on the same fetch.
+func (v *CallbackIPVerifier) callbackNets() []*net.IPNet {
+	v.mutex.Lock()
+	nets := v.nets
+	if nets == nil {
+		if time.Since(v.failedAt) < time.Minute {
+			v.mutex.Unlock()
+			return nil // do not call the API for every request while failing
+		}
+		fetching := v.fetching
+		if fetching != nil {
+			v.mutex.Unlock()
+			<-fetching
+		} else {
+			fetching = make(chan struct{})
+			v.fetching = fetching
+			v.mutex.Unlock()
+			v.fetch(fetching)
+		}
+
+		v.mutex.RLock()
+		defer v.mutex.RUnlock()
+		return v.nets
+	}
+
+	refresh := !v.refreshing && time.Since(v.refreshedAt) >= v.RefreshInterval
+	if refresh {
+		v.refreshing = true
+	}
+	v.mutex.Unlock()
+
+	if refresh {
+		go func() {
+			if err := v.Refresh(); err != nil {
+				v.logger.Errorw("Refresh callback IP list failed", "error", err)
+			}
+			v.mutex.Lock()
+			v.refreshing = false
+			v.mutex.Unlock()
+		}()
+	}
+	return nets
+}
+
+// fetch gets the list for the first time, and closes fetching when done.
+func (v *CallbackIPVerifier) fetch(fetching chan struct{}) {
+	err := v.Refresh()
+	if err != nil {
+		v.logger.Errorw("Get callback IP list failed", "error", err)
+	}
+
+	v.mutex.Lock()
+	if err != nil {
+		v.failedAt = time.Now()
+	}
+	v.fetching = nil
+	v.mutex.Unlock()
+	close(fetching)
+}
+
+// ClientIP returns the IP of the client, which is the remote address, or the address forwarded
+// by the trusted proxies if the remote address is a trusted proxy.
+func (v *CallbackIPVerifier) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !containsIP(v.trustedProxies, ip) {
+		return ip
+	}
+
+	// the rightmost address which is not a trusted proxy, as the left ones may be forged by the client
+	var forwarded []string
+	for _, h := range r.Header["X-Forwarded-For"] {
+		forwarded = append(forwarded, strings.Split(h, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip = net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			return nil
+		}
+		if !containsIP(v.trustedProxies, ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// Verify reports whether the request comes from the callback IPs of Wechat, and returns the client IP.
+func (v *CallbackIPVerifier) Verify(r *http.Request) (net.IP, bool) {
+	ip := v.ClientIP(r)
+	nets := v.callbackNets()
+	if nets == nil {
+		return ip, v.FailOpen
+	}
+	return ip, ip != nil && containsIP(nets, ip)
+}
+
+func (v *CallbackIPVerifier) reject(r *http.Request, ip net.IP) {
+	v.logger.Warnw("Request not from Wechat callback IPs", "ip", ip, "remoteAddr", r.RemoteAddr,
+		"forwardedFor", r.Header.Get("X-Forwarded-For"), "path", r.URL.Path)
+}
+
+// Handler rejects the requests to next with 403, if not from the callback IPs of Wechat.
+func (v *CallbackIPVerifier) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip, ok := v.Verify(r); !ok {
+			v.reject(r, ip)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Middleware is the mel version of Handler.
+func (v *CallbackIPVerifier) Middleware() mel.Handler {
+	return func(c *mel.Context) {
+		if ip, ok := v.Verify(c.Request); !ok {
+			v.reject(c.Request, ip)
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// VerifyCallbackIP makes the server reject the callback requests of messages and events,
+// which are not from the callback IPs of Wechat. The OAuth2 and JS-SDK routes are not affected.
+func (srv *Server) VerifyCallbackIP(v *CallbackIPVerifier) {
+	srv.callbackIPs = v
+}
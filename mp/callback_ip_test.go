@@ -0,0 +1,66 @@
+package mp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCallbackIPVerifier(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Write([]byte(`{"ip_list":["101.226.62.77","101.226.103.0/25"]}`))
+	})
+	v := NewCallbackIPVerifier(c)
+	if err := v.SetTrustedProxies("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       bool
+	}{
+		{name: "callback IP", remoteAddr: "101.226.62.77:1234", want: true},
+		{name: "callback CIDR", remoteAddr: "101.226.103.5:1234", want: true},
+		{name: "other IP", remoteAddr: "1.2.3.4:1234"},
+		{name: "forwarded by trusted proxy", remoteAddr: "10.0.0.1:1234", forwarded: "101.226.62.77", want: true},
+		{name: "forged before trusted proxy", remoteAddr: "10.0.0.1:1234", forwarded: "101.226.62.77, 1.2.3.4"},
+		{name: "forwarded by untrusted proxy", remoteAddr: "1.2.3.4:1234", forwarded: "101.226.62.77"},
+	}
+
+	// the concurrent requests on cold start share one fetch of the list
+	var wg sync.WaitGroup
+	results := make([]bool, len(tests))
+	for i, tt := range tests {
+		wg.Add(1)
+		go func(i int, remoteAddr, forwarded string) {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.RemoteAddr = remoteAddr
+			if forwarded != "" {
+				r.Header.Set("X-Forwarded-For", forwarded)
+			}
+			_, results[i] = v.Verify(r)
+		}(i, tt.remoteAddr, tt.forwarded)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("got the list %d times, want once", calls)
+	}
+	for i, tt := range tests {
+		if results[i] != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, results[i], tt.want)
+		}
+	}
+}
@@ -8,24 +8,43 @@ import (
 	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"fmt"
 	"strconv"
+	"sync"
+	"time"
 )
 
+// MaxBusyRetries is how many times a GET call is retried if Wechat responds with a retryable errcode, e.g. SystemBusy.
+// The POST calls are not retried, as they may have been processed by Wechat which responded busy,
+// e.g. a message would be sent twice.
+var MaxBusyRetries = 2
+
+// BusyRetryDelay is the delay before the first retry of MaxBusyRetries, which doubles for each retry.
+var BusyRetryDelay = 500 * time.Millisecond
+
 var BASE_URL URL = "https://api.weixin.qq.com/cgi-bin"
 var CORP_BASE_URL URL = "https://qyapi.weixin.qq.com/cgi-bin"
+var WXA_BASE_URL URL = "https://api.weixin.qq.com/wxaapi"
 
 type Client struct {
 	*TokenAccessor
 	*http.Client
 
 	AgentID int64 // corp app ID
+
+	JSSDKDomains []string // allowed domains of JSSDKConfig, "*.example.com" matches subdomains, empty allows any
+
+	ticketMutex       sync.Mutex
+	ticketRefreshedAt map[string]time.Time // ticket type to the last time of ForceRefreshTicket
 }
 
+// NewClient creates the client of the app. needsTicket is ignored, see NewTokenAccessor.
 func NewClient(appID, appSecret string, needsTicket bool) *Client {
 	return &Client{
 		TokenAccessor: NewTokenAccessor(appID, appSecret, needsTicket),
@@ -45,23 +64,35 @@ func (c *Client) urlAddAgentID(u URL) URL {
 	return u.Query("agentid", strconv.FormatInt(c.AgentID, 10))
 }
 
-func (c *Client) call(u URL, rep interface{}, streamRep io.Writer, request func(URL) (*http.Response, error)) error {
+// call requests u with the access token. idempotent is whether the request can be retried when Wechat is busy.
+func (c *Client) call(u URL, rep interface{}, streamRep io.Writer, idempotent bool, request func(URL) (*http.Response, error)) error {
 	token, err := c.Token()
 	if err != nil {
 		return err
 	}
 
+	observer := c.getObserver()
+	endpoint := endpointOf(u)
 	firstTime := true
+	busyRetries := 0
 
 RETRY:
+	start := time.Now()
+	completed := func(errCode int, err error) {
+		observer.APICallCompleted(endpoint, errCode, time.Since(start), err)
+	}
+
 	r, err := request(u.Query("access_token", token))
 	if err != nil {
+		completed(OK, err)
 		return err
 	}
 	defer r.Body.Close()
 
 	if r.StatusCode != http.StatusOK {
-		return fmt.Errorf("http.Status: %s", r.Status)
+		err = fmt.Errorf("http.Status: %s", r.Status)
+		completed(OK, err)
+		return err
 	}
 
 	if streamRep != nil {
@@ -69,16 +100,27 @@ RETRY:
 		contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
 		if contentDisposition != "" && contentType != "text/plain" && contentType != "application/json" {
 			_, err = io.Copy(streamRep, r.Body)
+			completed(OK, err)
 			return err
 		}
 	}
 
+	if !firstTime || busyRetries > 0 {
+		// clear the fields of the last response, which may be absent in this one
+		v := reflect.ValueOf(rep).Elem()
+		v.Set(reflect.Zero(v.Type()))
+	}
 	err = json.NewDecoder(r.Body).Decode(rep)
 	if err != nil {
+		completed(OK, err)
 		return err
 	}
 
 	e := rep.(Error)
+	completed(e.Code(), nil)
+	if p, ok := rep.(interface{ parseRid() }); ok && e.Code() != OK {
+		p.parseRid()
+	}
 	if e.Code() == OK {
 		return nil
 	}
@@ -90,12 +132,17 @@ RETRY:
 		}
 		goto RETRY
 	}
+	if idempotent && ErrClassOf(e.Code()) == ErrClassRetryable && busyRetries < MaxBusyRetries {
+		time.Sleep(BusyRetryDelay << uint(busyRetries))
+		busyRetries++
+		goto RETRY
+	}
 
 	return rep.(error)
 }
 
 func (c *Client) Get(u URL, rep interface{}) error {
-	return c.call(u, rep, nil, func(u URL) (*http.Response, error) {
+	return c.call(u, rep, nil, true, func(u URL) (*http.Response, error) {
 		return c.Client.Get(string(u))
 	})
 }
@@ -106,9 +153,11 @@ func (c *Client) Post(u URL, req, rep interface{}) error {
 	if err != nil {
 		return err
 	}
+	body := buf.Bytes()
 
-	return c.call(u, rep, nil, func(u URL) (*http.Response, error) {
-		return c.Client.Post(string(u), "application/json; charset=utf-8", buf)
+	return c.call(u, rep, nil, false, func(u URL) (*http.Response, error) {
+		// a new reader for each attempt, as the body is consumed by the last one
+		return c.Client.Post(string(u), "application/json; charset=utf-8", bytes.NewReader(body))
 	})
 }
 
@@ -134,6 +183,7 @@ func (fb *fileBuf) Write(p []byte) (n int, err error) {
 	if err != nil && err != io.EOF {
 		return
 	}
+	err = nil // io.EOF only means p fits in memory
 	if fb.n > MaxMemoryForFile && fb.File == nil {
 		// too big, write to disk and flush buffer
 		var file *os.File
@@ -162,28 +212,53 @@ func (fb *fileBuf) Write(p []byte) (n int, err error) {
 
 func (fb *fileBuf) Close() error {
 	if fb.File != nil {
-		return fb.File.Close()
+		err := fb.File.Close()
+		os.Remove(fb.File.Name())
+		return err
 	}
 	return nil
 }
 
+// ReadSeeker returns the buffered content for reading from the beginning.
+func (fb *fileBuf) ReadSeeker() (io.ReadSeeker, error) {
+	if fb.File != nil {
+		_, err := fb.File.Seek(0, 0)
+		return fb.File, err
+	}
+	return bytes.NewReader(fb.Buffer.Bytes()), nil
+}
+
 func (c *Client) UploadFile(u URL, name, filePath string, extraFields map[string]string, rep interface{}) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return c.UploadFromReader(u, name, file, filepath.Base(filePath), "", extraFields, rep)
+}
+
+// UploadFromReader uploads the content read from reader as a multipart file named fileName.
+// If contentType is empty, "application/octet-stream" is used. The request body is buffered
+// in memory or spilled to a temp file if larger than MaxMemoryForFile, so it can be re-sent on token expiry.
+func (c *Client) UploadFromReader(u URL, name string, reader io.Reader, fileName, contentType string, extraFields map[string]string, rep interface{}) error {
 	var buf fileBuf
 	defer buf.Close()
 
 	mp := multipart.NewWriter(&buf)
-	partWriter, err := mp.CreateFormFile(name, filepath.Base(filePath))
-	if err != nil {
-		return err
-	}
 
-	file, err := os.Open(filePath)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(name), escapeQuotes(fileName)))
+	header.Set("Content-Type", contentType)
+	partWriter, err := mp.CreatePart(header)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	if _, err = io.Copy(partWriter, file); err != nil {
+	if _, err = io.Copy(partWriter, reader); err != nil {
 		return err
 	}
 
@@ -197,22 +272,33 @@ func (c *Client) UploadFile(u URL, name, filePath string, extraFields map[string
 		return err
 	}
 
-	var reader io.ReadSeeker
-	if buf.File != nil {
-		reader = buf.File
-	} else {
-		reader = bytes.NewReader(buf.Buffer.Bytes())
+	body, err := buf.ReadSeeker()
+	if err != nil {
+		return err
 	}
 
-	return c.call(u, rep, nil, func(u URL) (*http.Response, error) {
-		_, err := reader.Seek(0, 0)
+	return c.call(u, rep, nil, false, func(u URL) (*http.Response, error) {
+		_, err := body.Seek(0, 0)
+		if err != nil {
+			return nil, err
+		}
+		// not closed by the transport, as the spilled file is read again by the retry
+		req, err := http.NewRequest(http.MethodPost, string(u), ioutil.NopCloser(body))
 		if err != nil {
 			return nil, err
 		}
-		return c.Client.Post(string(u), mp.FormDataContentType(), reader)
+		req.ContentLength = int64(buf.n)
+		req.Header.Set("Content-Type", mp.FormDataContentType())
+		return c.Client.Do(req)
 	})
 }
 
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
 func (c *Client) DownloadFile(u URL, req interface{}, filePath string, rep interface{}) (err error) {
 	file, err := os.Create(filePath)
 	if err != nil {
@@ -226,7 +312,13 @@ func (c *Client) DownloadFile(u URL, req interface{}, filePath string, rep inter
 		}
 	}()
 
-	return c.call(u, rep, file, func(u URL) (*http.Response, error) {
+	return c.DownloadToWriter(u, req, file, rep)
+}
+
+// DownloadToWriter downloads the file into w. If req is nil, it sends GET request, otherwise POST request with req as JSON.
+// Nothing is written into w if the response is an error.
+func (c *Client) DownloadToWriter(u URL, req interface{}, w io.Writer, rep interface{}) error {
+	return c.call(u, rep, w, req == nil, func(u URL) (*http.Response, error) {
 		if req == nil {
 			return c.Client.Get(string(u))
 		} else {
@@ -1 +1,152 @@
 package mp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client with a valid token, whose BASE_URL requests are served by handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	baseURL := BASE_URL
+	BASE_URL = URL(ts.URL + "/cgi-bin")
+	t.Cleanup(func() { BASE_URL = baseURL })
+
+	c := NewClient("appid", "secret", false)
+	c.token.mutex.Lock()
+	c.token.store("token", 7200)
+	c.token.mutex.Unlock()
+	return c
+}
+
+func TestBusyRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		post      bool
+		busy      int // responses of SystemBusy before OK
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "get retried until OK", busy: 2, wantCalls: 3},
+		{name: "get retries exhausted", busy: 3, wantCalls: 3, wantErr: true},
+		{name: "post not retried", post: true, busy: 1, wantCalls: 1, wantErr: true},
+	}
+
+	busyRetryDelay := BusyRetryDelay
+	BusyRetryDelay = time.Millisecond
+	defer func() { BusyRetryDelay = busyRetryDelay }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls <= tt.busy {
+					w.Write([]byte(`{"errcode":-1,"errmsg":"system error"}`))
+					return
+				}
+				w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+			})
+
+			var rep Err
+			var err error
+			if tt.post {
+				err = c.Post(BASE_URL.Join("/test"), map[string]string{"k": "v"}, &rep)
+			} else {
+				err = c.Get(BASE_URL.Join("/test"), &rep)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestPostRetryBody(t *testing.T) {
+	calls := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cgi-bin/token" {
+			w.Write([]byte(`{"access_token":"token2","expires_in":7200}`))
+			return
+		}
+		calls++
+		if body, _ := ioutil.ReadAll(r.Body); !strings.Contains(string(body), `"k":"v"`) {
+			t.Errorf("call %d has body %q", calls, body)
+		}
+		if calls == 1 {
+			w.Write([]byte(`{"errcode":42001,"errmsg":"access_token expired"}`))
+			return
+		}
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	})
+	c.url = string(BASE_URL.Join("/token"))
+
+	var rep Err
+	if err := c.Post(BASE_URL.Join("/test"), map[string]string{"k": "v"}, &rep); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("called %d times, want 2", calls)
+	}
+}
+
+func TestUploadFromReader(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxMemory int
+		size      int
+	}{
+		{name: "in memory", maxMemory: 1 << 20, size: 100},
+		{name: "spilled to disk", maxMemory: 64, size: 1000},
+	}
+
+	maxMemory := MaxMemoryForFile
+	defer func() { MaxMemoryForFile = maxMemory }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			MaxMemoryForFile = tt.maxMemory
+			content := strings.Repeat("x", tt.size)
+
+			calls := 0
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/cgi-bin/token" {
+					w.Write([]byte(`{"access_token":"token2","expires_in":7200}`))
+					return
+				}
+				calls++
+				file, _, err := r.FormFile("media")
+				if err != nil {
+					t.Fatalf("call %d: %v", calls, err)
+				}
+				defer file.Close()
+				if data, _ := ioutil.ReadAll(file); string(data) != content {
+					t.Errorf("call %d uploaded %d bytes, want %d", calls, len(data), len(content))
+				}
+				if calls == 1 { // the body is sent again after the token is refreshed
+					w.Write([]byte(`{"errcode":42001,"errmsg":"access_token expired"}`))
+					return
+				}
+				w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+			})
+			c.url = string(BASE_URL.Join("/token"))
+
+			var rep Err
+			if err := c.UploadFromReader(BASE_URL.Join("/upload"), "media", strings.NewReader(content), "file.txt", "", nil, &rep); err != nil {
+				t.Fatal(err)
+			}
+			if calls != 2 {
+				t.Errorf("called %d times, want 2", calls)
+			}
+		})
+	}
+}
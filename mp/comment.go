@@ -0,0 +1,137 @@
+package mp
+
+import (
+	"errors"
+)
+
+// Comment types of GetComments
+const (
+	CommentAll     = 0
+	CommentNormal  = 1 // not elected
+	CommentElected = 2
+)
+
+type CommentReply struct {
+	Content    string `json:"content"`
+	CreateTime int64  `json:"create_time"`
+}
+
+type Comment struct {
+	Id         int64         `json:"user_comment_id"`
+	OpenID     string        `json:"openid"`
+	CreateTime int64         `json:"create_time"`
+	Content    string        `json:"content"`
+	Elected    int           `json:"comment_type"` // 1 if elected
+	Reply      *CommentReply `json:"reply,omitempty"`
+}
+
+type CommentList struct {
+	Total    int       `json:"total"`
+	Comments []Comment `json:"comment"`
+}
+
+// The comments are managed per article, which is identified by the msg_data_id of the mass news,
+// returned by SendMassByUsers, or by SubmitPublish, and the index(0 based) of the article in the news.
+type commentTarget struct {
+	DataId int64 `json:"msg_data_id"`
+	Index  int   `json:"index"`
+}
+
+func (c *Client) postComment(path string, req interface{}) error {
+	var rep Err
+	return c.Post(BASE_URL.Join("/comment"+path), req, &rep)
+}
+
+func (c *Client) OpenComment(msgDataId int64, index int) error {
+	return c.postComment("/open", &commentTarget{msgDataId, index})
+}
+
+func (c *Client) CloseComment(msgDataId int64, index int) error {
+	return c.postComment("/close", &commentTarget{msgDataId, index})
+}
+
+// GetComments gets count(no more than 50) comments from begin, of the commentType.
+func (c *Client) GetComments(msgDataId int64, index, begin, count, commentType int) (commentList *CommentList, err error) {
+	u := BASE_URL.Join("/comment/list")
+
+	if count < 1 || count > 50 {
+		err = errors.New("GetComments valid count range is [1,50]")
+		return
+	}
+
+	var req = struct {
+		commentTarget
+		Begin int `json:"begin"`
+		Count int `json:"count"`
+		Type  int `json:"type"`
+	}{
+		commentTarget: commentTarget{msgDataId, index},
+		Begin:         begin,
+		Count:         count,
+		Type:          commentType,
+	}
+
+	var rep struct {
+		Err
+		CommentList
+	}
+
+	err = c.Post(u, &req, &rep)
+	if err != nil {
+		return
+	}
+
+	commentList = &rep.CommentList
+	return
+}
+
+type commentReq struct {
+	commentTarget
+	CommentId int64  `json:"user_comment_id"`
+	Content   string `json:"content,omitempty"`
+}
+
+func (c *Client) MarkElectComment(msgDataId int64, index int, commentId int64) error {
+	return c.postComment("/markelect", &commentReq{commentTarget: commentTarget{msgDataId, index}, CommentId: commentId})
+}
+
+func (c *Client) UnmarkElectComment(msgDataId int64, index int, commentId int64) error {
+	return c.postComment("/unmarkelect", &commentReq{commentTarget: commentTarget{msgDataId, index}, CommentId: commentId})
+}
+
+func (c *Client) DeleteComment(msgDataId int64, index int, commentId int64) error {
+	return c.postComment("/delete", &commentReq{commentTarget: commentTarget{msgDataId, index}, CommentId: commentId})
+}
+
+func (c *Client) ReplyComment(msgDataId int64, index int, commentId int64, content string) error {
+	return c.postComment("/reply/add", &commentReq{commentTarget: commentTarget{msgDataId, index}, CommentId: commentId, Content: content})
+}
+
+func (c *Client) DeleteCommentReply(msgDataId int64, index int, commentId int64) error {
+	return c.postComment("/reply/delete", &commentReq{commentTarget: commentTarget{msgDataId, index}, CommentId: commentId})
+}
+
+type CommentIterator struct {
+	pager
+	items []Comment
+}
+
+// IterateComments iterates the comments of the commentType with pageSize comments per request,
+// DefaultPageSize if pageSize is 0. Deleting comments while iterating may skip some comments,
+// as the later comments move forward.
+func (c *Client) IterateComments(msgDataId int64, index, commentType, pageSize int) *CommentIterator {
+	it := &CommentIterator{}
+	it.pager = newPager(pageSize, func(offset, count int) (int, bool, error) {
+		list, err := c.GetComments(msgDataId, index, offset, count, commentType)
+		if err != nil {
+			return 0, false, err
+		}
+		it.items = list.Comments
+		return len(list.Comments), offset+len(list.Comments) < list.Total, nil
+	})
+	return it
+}
+
+func (it *CommentIterator) Value() *Comment {
+	return &it.items[it.index]
+}
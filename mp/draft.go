@@ -0,0 +1,172 @@
+package mp
+
+import (
+	"errors"
+)
+
+type Draft struct {
+	MediaID    string `json:"media_id"`
+	UpdateTime int64  `json:"update_time"`
+	Content    struct {
+		Articles []Article `json:"news_item,omitempty"`
+	} `json:"content"`
+}
+
+type DraftList struct {
+	TotalCount int     `json:"total_count"`
+	ItemCount  int     `json:"item_count"` // item count of this time GetDrafts
+	Items      []Draft `json:"item"`
+}
+
+// AddDraft adds the articles into the draft box, which can be published by SubmitPublish.
+func (c *Client) AddDraft(articles []Article) (mediaId string, err error) {
+	u := BASE_URL.Join("/draft/add")
+
+	var req = struct {
+		Articles []Article `json:"articles"`
+	}{
+		Articles: articles,
+	}
+
+	var rep struct {
+		Err
+		Id string `json:"media_id"`
+	}
+
+	err = c.Post(u, &req, &rep)
+	if err != nil {
+		return
+	}
+
+	mediaId = rep.Id
+	return
+}
+
+func (c *Client) GetDraft(mediaId string) (articles []Article, err error) {
+	u := BASE_URL.Join("/draft/get")
+
+	var req = struct {
+		Id string `json:"media_id"`
+	}{
+		Id: mediaId,
+	}
+
+	var rep struct {
+		Err
+		Articles []Article `json:"news_item"`
+	}
+
+	err = c.Post(u, &req, &rep)
+	if err != nil {
+		return
+	}
+
+	articles = rep.Articles
+	return
+}
+
+// UpdateDraft updates the index-th(0 based) article in the draft which has media id mediaId.
+func (c *Client) UpdateDraft(mediaId string, index int, article *Article) error {
+	u := BASE_URL.Join("/draft/update")
+
+	var req = struct {
+		Id      string   `json:"media_id"`
+		Index   int      `json:"index"`
+		Article *Article `json:"articles"`
+	}{
+		Id:      mediaId,
+		Index:   index,
+		Article: article,
+	}
+
+	var rep Err
+	return c.Post(u, &req, &rep)
+}
+
+func (c *Client) DeleteDraft(mediaId string) error {
+	u := BASE_URL.Join("/draft/delete")
+
+	var req = struct {
+		Id string `json:"media_id"`
+	}{
+		Id: mediaId,
+	}
+
+	var rep Err
+	return c.Post(u, &req, &rep)
+}
+
+func (c *Client) GetDraftCount() (count int, err error) {
+	u := BASE_URL.Join("/draft/count")
+
+	var rep struct {
+		Err
+		Count int `json:"total_count"`
+	}
+
+	err = c.Get(u, &rep)
+	if err != nil {
+		return
+	}
+
+	count = rep.Count
+	return
+}
+
+// GetDrafts gets count drafts from offset. If noContent is true, the Content of the articles are omitted.
+func (c *Client) GetDrafts(offset, count int, noContent bool) (draftList *DraftList, err error) {
+	u := BASE_URL.Join("/draft/batchget")
+
+	if count < 1 || count > 20 {
+		err = errors.New("GetDrafts valid count range is [1,20]")
+		return
+	}
+
+	var req = struct {
+		Offset    int `json:"offset"`
+		Count     int `json:"count"`
+		NoContent int `json:"no_content"`
+	}{
+		Offset: offset,
+		Count:  count,
+	}
+	if noContent {
+		req.NoContent = 1
+	}
+
+	var rep struct {
+		Err
+		DraftList
+	}
+
+	err = c.Post(u, &req, &rep)
+	if err != nil {
+		return
+	}
+
+	draftList = &rep.DraftList
+	return
+}
+
+type DraftIterator struct {
+	pager
+	items []Draft
+}
+
+// IterateDrafts iterates all drafts with pageSize drafts per request, DefaultPageSize if pageSize is 0.
+func (c *Client) IterateDrafts(pageSize int, noContent bool) *DraftIterator {
+	it := &DraftIterator{}
+	it.pager = newPager(pageSize, func(offset, count int) (int, bool, error) {
+		list, err := c.GetDrafts(offset, count, noContent)
+		if err != nil {
+			return 0, false, err
+		}
+		it.items = list.Items
+		return len(list.Items), offset+len(list.Items) < list.TotalCount, nil
+	})
+	return it
+}
+
+func (it *DraftIterator) Value() *Draft {
+	return &it.items[it.index]
+}
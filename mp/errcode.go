@@ -0,0 +1,251 @@
+package mp
+
+import (
+	"fmt"
+)
+
+// ErrClass classifies the errcodes. It is an error, so that errors.Is(err, ErrClassQuota) reports
+// whether err is an *Err of the class.
+type ErrClass int
+
+const (
+	ErrClassUnknown   ErrClass = iota // not in the catalogue
+	ErrClassRetryable                 // transient failure of Wechat, the same request can be retried
+	ErrClassAuth                      // the app credential, the access token or the permission is invalid
+	ErrClassUser                      // caused by the state of the user, e.g. unsubscribed or refused to receive
+	ErrClassQuota                     // a quota or frequency limit is exceeded, the request can be retried later
+	ErrClassParameter                 // the request is invalid, retrying does not help
+)
+
+var errClassNames = [...]string{
+	ErrClassUnknown:   "unknown",
+	ErrClassRetryable: "retryable",
+	ErrClassAuth:      "auth",
+	ErrClassUser:      "user",
+	ErrClassQuota:     "quota",
+	ErrClassParameter: "parameter",
+}
+
+func (class ErrClass) String() string {
+	if class < 0 || int(class) >= len(errClassNames) {
+		return fmt.Sprintf("ErrClass(%d)", int(class))
+	}
+	return errClassNames[class]
+}
+
+func (class ErrClass) Error() string {
+	return "wechat error class: " + class.String()
+}
+
+// ErrInfo describes an errcode of the catalogue. The ErrInfos are sentinel errors,
+// errors.Is(err, ErrAccessTokenExpired) reports whether err is an *Err of the errcode,
+// and errors.As(err, &info) gets the ErrInfo of err.
+type ErrInfo struct {
+	Code  int
+	Class ErrClass
+	Desc  string
+}
+
+func (info *ErrInfo) Error() string {
+	return fmt.Sprintf("errcode: %d, %s", info.Code, info.Desc)
+}
+
+// More errcodes
+const (
+	InvalidRefreshToken     = 40030
+	InvalidAppSecret        = 40125
+	Oauth2CodeUsed          = 40163
+	InvalidIP               = 40164 // not in the IP whitelist
+	RefreshTokenExpired     = 42002
+	Oauth2CodeExpired       = 42003
+	APIFrequencyLimited     = 45011
+	ReplyTimeExceeded       = 45015 // the 48 hours of customer service messages are over
+	ClientMsgIDRetryTooFast = 45066
+	APIUnauthorized         = 48001
+	APIBlocked              = 48004
+	ClearQuotaExceeded      = 48006
+	UserLimited             = 50002
+)
+
+var errInfos = map[int]*ErrInfo{}
+
+func newErrInfo(code int, class ErrClass, desc string) *ErrInfo {
+	info := &ErrInfo{Code: code, Class: class, Desc: desc}
+	errInfos[code] = info
+	return info
+}
+
+// Sentinel errors of the named errcodes
+var (
+	ErrSystemBusy              = newErrInfo(SystemBusy, ErrClassRetryable, "system busy")
+	ErrInvalidCredential       = newErrInfo(InvalidCredential, ErrClassAuth, "invalid credential, the AppSecret is wrong or the access token is invalid")
+	ErrInvalidOpenID           = newErrInfo(InvalidOpenID, ErrClassParameter, "invalid openid, not a user of the app")
+	ErrInvalidMediaID          = newErrInfo(InvalidMediaID, ErrClassParameter, "invalid media_id")
+	ErrInvalidAccessToken      = newErrInfo(InvalidAccessToken, ErrClassAuth, "invalid access token")
+	ErrInvalidAppSecret        = newErrInfo(InvalidAppSecret, ErrClassAuth, "invalid AppSecret")
+	ErrInvalidIP               = newErrInfo(InvalidIP, ErrClassAuth, "the IP is not in the whitelist")
+	ErrAccessTokenExpired      = newErrInfo(AccessTokenExpired, ErrClassAuth, "access token expired")
+	ErrRefreshTokenExpired     = newErrInfo(RefreshTokenExpired, ErrClassAuth, "refresh token expired")
+	ErrOauth2CodeExpired       = newErrInfo(Oauth2CodeExpired, ErrClassAuth, "oauth2 code expired")
+	ErrUserUnsubscribed        = newErrInfo(UserUnsubscribed, ErrClassUser, "the user is not subscribed")
+	ErrUserRefusedToReceive    = newErrInfo(UserRefusedToReceive, ErrClassUser, "the user refused to receive the messages")
+	ErrAPIQuotaExceeded        = newErrInfo(APIQuotaExceeded, ErrClassQuota, "the daily quota of the API is exceeded")
+	ErrAPIFrequencyLimited     = newErrInfo(APIFrequencyLimited, ErrClassQuota, "the API is called too frequently, try later")
+	ErrReplyTimeExceeded       = newErrInfo(ReplyTimeExceeded, ErrClassUser, "the time limit of replying to the user is exceeded")
+	ErrClientMsgIDExist        = newErrInfo(ClientMsgIDExist, ErrClassParameter, "the clientmsgid has been used in 24 hours")
+	ErrClientMsgIDRetryTooFast = newErrInfo(ClientMsgIDRetryTooFast, ErrClassQuota, "retried the same clientmsgid too fast, retry after 1 minute")
+	ErrMenuNotExist            = newErrInfo(MenuNotExist, ErrClassParameter, "the menu does not exist")
+	ErrAPIUnauthorized         = newErrInfo(APIUnauthorized, ErrClassAuth, "the app is not authorized to call the API")
+	ErrAPIBlocked              = newErrInfo(APIBlocked, ErrClassAuth, "the API is blocked")
+	ErrClearQuotaExceeded      = newErrInfo(ClearQuotaExceeded, ErrClassQuota, "the monthly times of clearing the quota are exceeded")
+	ErrUserLimited             = newErrInfo(UserLimited, ErrClassUser, "the user is limited")
+)
+
+func init() {
+	for _, info := range []ErrInfo{
+		{40002, ErrClassParameter, "invalid grant_type"},
+		{40004, ErrClassParameter, "invalid media type"},
+		{40005, ErrClassParameter, "invalid file type"},
+		{40006, ErrClassParameter, "invalid file size"},
+		{40008, ErrClassParameter, "invalid message type"},
+		{40009, ErrClassParameter, "invalid image size"},
+		{40010, ErrClassParameter, "invalid voice size"},
+		{40011, ErrClassParameter, "invalid video size"},
+		{40012, ErrClassParameter, "invalid thumb size"},
+		{40013, ErrClassAuth, "invalid AppID"},
+		{40015, ErrClassParameter, "invalid menu type"},
+		{40016, ErrClassParameter, "invalid number of buttons"},
+		{40017, ErrClassParameter, "invalid button type"},
+		{40018, ErrClassParameter, "invalid button name length"},
+		{40019, ErrClassParameter, "invalid button key length"},
+		{40020, ErrClassParameter, "invalid button URL length"},
+		{40021, ErrClassParameter, "invalid menu version"},
+		{40022, ErrClassParameter, "invalid number of sub buttons"},
+		{40023, ErrClassParameter, "invalid sub button type"},
+		{40024, ErrClassParameter, "invalid sub button name length"},
+		{40025, ErrClassParameter, "invalid sub button key length"},
+		{40026, ErrClassParameter, "invalid sub button URL length"},
+		{40027, ErrClassParameter, "invalid menu user"},
+		{40028, ErrClassParameter, "invalid menu user"},
+		{40029, ErrClassAuth, "invalid oauth2 code"},
+		{InvalidRefreshToken, ErrClassAuth, "invalid refresh token"},
+		{40031, ErrClassParameter, "invalid openid list"},
+		{40032, ErrClassParameter, "invalid length of openid list"},
+		{40033, ErrClassParameter, "invalid characters, \\uxxxx is not allowed"},
+		{40035, ErrClassParameter, "invalid parameter"},
+		{40038, ErrClassParameter, "invalid request format"},
+		{40039, ErrClassParameter, "invalid URL length"},
+		{40048, ErrClassParameter, "invalid URL domain"},
+		{40050, ErrClassParameter, "invalid group ID"},
+		{40051, ErrClassParameter, "invalid group name"},
+		{40054, ErrClassParameter, "invalid sub button URL domain"},
+		{40055, ErrClassParameter, "invalid button URL domain"},
+		{40066, ErrClassParameter, "invalid URL"},
+		{40097, ErrClassParameter, "invalid arguments"},
+		{40117, ErrClassParameter, "invalid group name"},
+		{40118, ErrClassParameter, "invalid media_id size"},
+		{40119, ErrClassParameter, "invalid button type"},
+		{40120, ErrClassParameter, "invalid sub button type"},
+		{40121, ErrClassParameter, "invalid media_id type for the menu"},
+		{40132, ErrClassParameter, "invalid Wechat ID"},
+		{40137, ErrClassParameter, "unsupported image format"},
+		{40155, ErrClassParameter, "links to the home pages of other official accounts are not allowed"},
+		{Oauth2CodeUsed, ErrClassAuth, "oauth2 code has been used"},
+		{41001, ErrClassAuth, "missing access_token"},
+		{41002, ErrClassParameter, "missing appid"},
+		{41003, ErrClassParameter, "missing refresh_token"},
+		{41004, ErrClassParameter, "missing secret"},
+		{41005, ErrClassParameter, "missing media data"},
+		{41006, ErrClassParameter, "missing media_id"},
+		{41007, ErrClassParameter, "missing sub menu data"},
+		{41008, ErrClassParameter, "missing oauth2 code"},
+		{41009, ErrClassParameter, "missing openid"},
+		{42007, ErrClassAuth, "the user changed the password, the tokens are invalid"},
+		{43001, ErrClassParameter, "GET request required"},
+		{43002, ErrClassParameter, "POST request required"},
+		{43003, ErrClassParameter, "HTTPS request required"},
+		{43005, ErrClassUser, "friend relationship required"},
+		{43019, ErrClassUser, "the user is in the blacklist"},
+		{43116, ErrClassAuth, "the template is limited for abuse"},
+		{44001, ErrClassParameter, "empty media data"},
+		{44002, ErrClassParameter, "empty POST data"},
+		{44003, ErrClassParameter, "empty news content"},
+		{44004, ErrClassParameter, "empty text content"},
+		{45001, ErrClassParameter, "media size exceeds the limit"},
+		{45002, ErrClassParameter, "message content exceeds the limit"},
+		{45003, ErrClassParameter, "title exceeds the limit"},
+		{45004, ErrClassParameter, "description exceeds the limit"},
+		{45005, ErrClassParameter, "URL exceeds the limit"},
+		{45006, ErrClassParameter, "picture URL exceeds the limit"},
+		{45007, ErrClassParameter, "voice duration exceeds the limit"},
+		{45008, ErrClassParameter, "number of articles exceeds the limit"},
+		{45010, ErrClassParameter, "number of menus exceeds the limit"},
+		{45016, ErrClassParameter, "the system group cannot be modified"},
+		{45017, ErrClassParameter, "group name too long"},
+		{45018, ErrClassParameter, "number of groups exceeds the limit"},
+		{45028, ErrClassQuota, "no quota of mass messages"},
+		{45047, ErrClassUser, "number of customer service messages exceeds the limit before the user replies"},
+		{45056, ErrClassParameter, "number of tags exceeds the limit"},
+		{45057, ErrClassParameter, "tags with more than 100000 users cannot be deleted"},
+		{45058, ErrClassParameter, "the default tags cannot be modified"},
+		{45059, ErrClassUser, "the user has too many tags"},
+		{45067, ErrClassParameter, "clientmsgid too long"},
+		{46001, ErrClassParameter, "media data does not exist"},
+		{46002, ErrClassParameter, "menu version does not exist"},
+		{46004, ErrClassParameter, "the user does not exist"},
+		{47001, ErrClassParameter, "invalid JSON or XML"},
+		{47003, ErrClassParameter, "invalid template parameter"},
+		{48002, ErrClassUser, "the user blocked the messages"},
+		{48005, ErrClassParameter, "the media is used by the auto reply or the menu, and cannot be deleted"},
+		{48008, ErrClassAuth, "no permission of the message type"},
+		{50001, ErrClassAuth, "the user has not authorized the API"},
+		{50005, ErrClassUser, "the user has not subscribed"},
+		{53503, ErrClassParameter, "the draft failed the check before publishing"},
+		{53504, ErrClassParameter, "the draft must be published on the Official Accounts Platform"},
+		{53505, ErrClassParameter, "the draft must be saved manually before publishing"},
+		{53600, ErrClassParameter, "invalid article ID"},
+		{61450, ErrClassRetryable, "system error"},
+		{61451, ErrClassParameter, "invalid parameter"},
+		{61452, ErrClassParameter, "invalid customer service account"},
+		{61453, ErrClassParameter, "customer service account exists"},
+		{61454, ErrClassParameter, "customer service nickname too long"},
+		{61455, ErrClassParameter, "invalid characters in customer service account"},
+		{61456, ErrClassParameter, "number of customer service accounts exceeds the limit"},
+		{61457, ErrClassParameter, "invalid avatar file type"},
+		{61500, ErrClassParameter, "invalid date format"},
+		{63001, ErrClassParameter, "some parameters are empty"},
+		{63002, ErrClassAuth, "invalid signature"},
+		{65301, ErrClassParameter, "the conditional menu does not exist"},
+		{65302, ErrClassParameter, "no matched user"},
+		{65303, ErrClassParameter, "no default menu, create it first"},
+		{65304, ErrClassParameter, "empty match rule"},
+		{65305, ErrClassParameter, "number of conditional menus exceeds the limit"},
+		{65306, ErrClassAuth, "conditional menus are not supported"},
+		{65307, ErrClassParameter, "empty conditional menu"},
+		{65308, ErrClassParameter, "button has no response type"},
+		{65309, ErrClassAuth, "conditional menus are switched off"},
+		{87009, ErrClassAuth, "invalid signature"},
+		{88000, ErrClassAuth, "no permission of comments"},
+		{89501, ErrClassAuth, "the IP is waiting for the confirmation of the admin"},
+		{89503, ErrClassAuth, "the IP requires the confirmation of the admin"},
+		{89506, ErrClassAuth, "the IP was rejected by the admin in 24 hours"},
+		{89507, ErrClassAuth, "the IP was rejected by the admin in 1 hour"},
+		{9001001, ErrClassParameter, "invalid POST data"},
+		{9001002, ErrClassRetryable, "remote service unavailable"},
+	} {
+		newErrInfo(info.Code, info.Class, info.Desc)
+	}
+}
+
+// LookupErrCode returns the ErrInfo of the errcode, or nil if not in the catalogue.
+func LookupErrCode(code int) *ErrInfo {
+	return errInfos[code]
+}
+
+// ErrClassOf returns the class of the errcode, ErrClassUnknown if not in the catalogue.
+func ErrClassOf(code int) ErrClass {
+	if info := errInfos[code]; info != nil {
+		return info.Class
+	}
+	return ErrClassUnknown
+}
@@ -0,0 +1,96 @@
+package mp
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrClassOf(t *testing.T) {
+	tests := []struct {
+		code int
+		want ErrClass
+	}{
+		{SystemBusy, ErrClassRetryable},
+		{InvalidCredential, ErrClassAuth},
+		{AccessTokenExpired, ErrClassAuth},
+		{UserUnsubscribed, ErrClassUser},
+		{APIQuotaExceeded, ErrClassQuota},
+		{ClientMsgIDRetryTooFast, ErrClassQuota},
+		{InvalidOpenID, ErrClassParameter},
+		{ClientMsgIDExist, ErrClassParameter},
+		{InvalidRefreshToken, ErrClassAuth},
+		{OK, ErrClassUnknown},
+		{99999, ErrClassUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.code), func(t *testing.T) {
+			if got := ErrClassOf(tt.code); got != tt.want {
+				t.Errorf("ErrClassOf(%d) = %v, want %v", tt.code, got, tt.want)
+			}
+			if got := (&Err{ErrCode: tt.code}).Class(); got != tt.want {
+				t.Errorf("Class() = %v, want %v", got, tt.want)
+			}
+			if info := LookupErrCode(tt.code); (info != nil) != (tt.want != ErrClassUnknown) {
+				t.Errorf("LookupErrCode(%d) = %v", tt.code, info)
+			}
+		})
+	}
+}
+
+func TestErrIsAs(t *testing.T) {
+	// the responses embed Err, and the errors may be wrapped
+	type response struct {
+		Err
+		MsgID int64 `json:"msgid"`
+	}
+	busy := &response{Err: Err{ErrCode: SystemBusy, ErrMsg: "system error"}}
+	quota := fmt.Errorf("send: %w", &Err{ErrCode: APIQuotaExceeded, ErrMsg: "reach max api daily quota limit"})
+	unknown := &Err{ErrCode: 99999, ErrMsg: "unknown"}
+
+	tests := []struct {
+		name      string
+		err       error
+		target    error
+		wantIs    bool
+		wantCode  int // of errors.As **ErrInfo, 0 if not in the catalogue
+		notWechat bool
+	}{
+		{name: "sentinel of response", err: busy, target: ErrSystemBusy, wantIs: true, wantCode: SystemBusy},
+		{name: "class of response", err: busy, target: ErrClassRetryable, wantIs: true, wantCode: SystemBusy},
+		{name: "other sentinel", err: busy, target: ErrAPIQuotaExceeded, wantCode: SystemBusy},
+		{name: "other class", err: busy, target: ErrClassQuota, wantCode: SystemBusy},
+		{name: "wrapped sentinel", err: quota, target: ErrAPIQuotaExceeded, wantIs: true, wantCode: APIQuotaExceeded},
+		{name: "wrapped class", err: quota, target: ErrClassQuota, wantIs: true, wantCode: APIQuotaExceeded},
+		{name: "unknown class", err: unknown, target: ErrClassUnknown, wantIs: true},
+		{name: "not wechat error", err: errors.New("EOF"), target: ErrClassUnknown, notWechat: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errors.Is(tt.err, tt.target); got != tt.wantIs {
+				t.Errorf("errors.Is(%v, %v) = %v, want %v", tt.err, tt.target, got, tt.wantIs)
+			}
+
+			var info *ErrInfo
+			if ok := errors.As(tt.err, &info); ok != (tt.wantCode != 0) || (ok && info.Code != tt.wantCode) {
+				t.Errorf("errors.As(%v, *ErrInfo) = %v, %v, want code %d", tt.err, ok, info, tt.wantCode)
+			}
+
+			var e *Err
+			if ok := errors.As(tt.err, &e); ok == tt.notWechat {
+				t.Errorf("errors.As(%v, *Err) = %v", tt.err, ok)
+			}
+		})
+	}
+}
+
+func TestErrClassString(t *testing.T) {
+	if s := ErrClassQuota.String(); s != "quota" {
+		t.Errorf("String() = %q", s)
+	}
+	if s := ErrClass(100).String(); s != "ErrClass(100)" {
+		t.Errorf("String() = %q", s)
+	}
+}
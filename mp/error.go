@@ -1,46 +1,125 @@
 package mp
 
-import "fmt"
+import (
+	"fmt"
+	"regexp"
+)
 
 const (
-    OK = 0
-    InvalidCredential = 40001
-    AccessTokenExpired = 42001
+	SystemBusy           = -1
+	OK                   = 0
+	InvalidCredential    = 40001
+	InvalidOpenID        = 40003
+	InvalidMediaID       = 40007
+	InvalidAccessToken   = 40014
+	AccessTokenExpired   = 42001
+	UserUnsubscribed     = 43004
+	UserRefusedToReceive = 43101
+	APIQuotaExceeded     = 45009
+	ClientMsgIDExist     = 45065
+	MenuNotExist         = 46003
 )
 
 type Error interface {
-    error
-    Code() int
-    Msg() string
+	error
+	Code() int
+	Msg() string
+}
+
+// RequestIDer is implemented by the errors carrying the rid of Wechat, such as *Err:
+//
+//	if e, ok := err.(RequestIDer); ok {
+//		rid := e.RequestID()
+//	}
+type RequestIDer interface {
+	RequestID() string
 }
 
 var _ Error = &Err{}
 var _ error = &Err{}
+var _ RequestIDer = &Err{}
 
 type Err struct {
-    ErrCode int  `json:"errcode"`
-    ErrMsg  string `json:"errmsg"`
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+	Rid     string `json:"-"` // request ID appended to errmsg by Wechat, which can be looked up by GetRid
 }
 
 func (err *Err) Error() string {
-    return fmt.Sprintf("errcode: %d, errmsg: %s", err.ErrCode, err.ErrMsg)
+	return fmt.Sprintf("errcode: %d, errmsg: %s", err.ErrCode, err.ErrMsg)
 }
 
 func (err *Err) Code() int {
-    return err.ErrCode
+	return err.ErrCode
 }
 
 func (err *Err) Msg() string {
-    return err.ErrMsg
+	return err.ErrMsg
+}
+
+// RequestID returns the rid in errmsg, which can be looked up by GetRid.
+func (err *Err) RequestID() string {
+	if err.Rid == "" {
+		return parseRid(err.ErrMsg)
+	}
+	return err.Rid
+}
+
+// Class returns the class of the errcode, see ErrClass.
+func (err *Err) Class() ErrClass {
+	return ErrClassOf(err.ErrCode)
+}
+
+// Is makes errors.Is(err, target) report whether the errcode is of target,
+// which is an *ErrInfo such as ErrAccessTokenExpired, or an ErrClass such as ErrClassQuota.
+func (err *Err) Is(target error) bool {
+	switch t := target.(type) {
+	case *ErrInfo:
+		return t.Code == err.ErrCode
+	case ErrClass:
+		return t == err.Class()
+	}
+	return false
+}
+
+// As makes errors.As(err, target) work for the errors of the responses embedding Err,
+// target may be **Err, or **ErrInfo if the errcode is in the catalogue.
+func (err *Err) As(target interface{}) bool {
+	switch t := target.(type) {
+	case **Err:
+		*t = err
+		return true
+	case **ErrInfo:
+		if info := LookupErrCode(err.ErrCode); info != nil {
+			*t = info
+			return true
+		}
+	}
+	return false
+}
+
+func (err *Err) parseRid() {
+	err.Rid = parseRid(err.ErrMsg)
+}
+
+var ridRegexp = regexp.MustCompile(`\brid:\s*([0-9A-Za-z-]+)`)
+
+// parseRid parses the rid from errmsg, e.g. "invalid credential, access_token is invalid or not latest rid: 5f3d8c1a-2b4e6f80-1a2b3c4d".
+func parseRid(errMsg string) string {
+	m := ridRegexp.FindStringSubmatch(errMsg)
+	if m == nil {
+		return ""
+	}
+	return m[1]
 }
 
 type CorpErr struct {
-    Err
-    InvalidUSer string `json:"invaliduser"`
-    InvalidParty string `json:"invalidparty"`
-    InvalidTag string `json:"invalidtag"`
+	Err
+	InvalidUSer  string `json:"invaliduser"`
+	InvalidParty string `json:"invalidparty"`
+	InvalidTag   string `json:"invalidtag"`
 }
 
 func (err *CorpErr) Error() string {
-    return fmt.Sprintf("errcode: %d, errmsg: %s, invalid: %s, %s, %s", err.ErrCode, err.ErrMsg, err.InvalidUSer, err.InvalidParty, err.InvalidTag)
+	return fmt.Sprintf("errcode: %d, errmsg: %s, invalid: %s, %s, %s", err.ErrCode, err.ErrMsg, err.InvalidUSer, err.InvalidParty, err.InvalidTag)
 }
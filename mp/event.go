@@ -1,5 +1,7 @@
 package mp
 
+import "encoding/xml"
+
 // Received message types
 const (
 	MessageText  = "text"
@@ -44,6 +46,12 @@ const (
 	EventUserScanProductAsync        = "user_scan_product_async"
 	EventUserScanProductVerifyAction = "user_scan_product_verify_action"
 	EventShakeAroundUserShake        = "ShakearoundUserShake"
+	EventTemplateSendJobFinish       = "TEMPLATESENDJOBFINISH"
+	EventMassSendJobFinish           = "MASSSENDJOBFINISH"
+	EventSubscribeMsgPopup           = "subscribe_msg_popup_event"
+	EventSubscribeMsgChange          = "subscribe_msg_change_event"
+	EventSubscribeMsgSent            = "subscribe_msg_sent_event"
+	EventPublishJobFinish            = "PUBLISHJOBFINISH"
 )
 
 type EventHeader struct {
@@ -58,7 +66,7 @@ type Event struct {
 
 	Event string `xml:"Event" json:"Event"`
 
-	MsgId        int     `xml:"MsgId"        json:"MsgId"`
+	MsgId        int64   `xml:"MsgId"        json:"MsgId"` // also the MsgID of TEMPLATESENDJOBFINISH and MASSSENDJOBFINISH
 	Content      string  `xml:"Content"      json:"Content"`
 	MediaId      string  `xml:"MediaId"      json:"MediaId"`
 	PicURL       string  `xml:"PicUrl"       json:"PicUrl"`
@@ -99,10 +107,39 @@ type Event struct {
 
 	Status string `xml:"Status" json:"Status"`
 
+	// counts of MASSSENDJOBFINISH
+	TotalCount  int `xml:"TotalCount"  json:"TotalCount"`
+	FilterCount int `xml:"FilterCount" json:"FilterCount"`
+	SentCount   int `xml:"SentCount"   json:"SentCount"`
+	ErrorCount  int `xml:"ErrorCount"  json:"ErrorCount"`
+
 	*AgentSessionChange
 
 	ChosenBeacon  *Beacon `xml:"ChosenBeacon,omitempty" json:"ChosenBeacon,omitempty"`
 	AroundBeacons *Beacon `xml:"AroundBeacons>AroundBeacon,omitempty" json:"AroundBeacons,omitempty"`
+
+	SubscribeMsgPopupEvent  []SubscribeMsgChange `xml:"SubscribeMsgPopupEvent>List,omitempty"  json:"SubscribeMsgPopupEvent,omitempty"`
+	SubscribeMsgChangeEvent []SubscribeMsgChange `xml:"SubscribeMsgChangeEvent>List,omitempty" json:"SubscribeMsgChangeEvent,omitempty"`
+	SubscribeMsgSentEvent   []SubscribeMsgSent   `xml:"SubscribeMsgSentEvent>List,omitempty"   json:"SubscribeMsgSentEvent,omitempty"`
+
+	PublishEventInfo *PublishStatus `xml:"PublishEventInfo,omitempty" json:"PublishEventInfo,omitempty"`
+}
+
+// UnmarshalXML decodes the MsgID of TEMPLATESENDJOBFINISH and MASSSENDJOBFINISH into MsgId,
+// which is named MsgId by the other messages.
+func (e *Event) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type event Event // without the method
+	v := struct {
+		*event
+		MsgID int64 `xml:"MsgID"`
+	}{event: (*event)(e)}
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	if v.MsgID != 0 {
+		e.MsgId = v.MsgID
+	}
+	return nil
 }
 
 type AgentSessionChange struct {
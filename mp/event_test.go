@@ -0,0 +1,45 @@
+package mp
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestEventUnmarshalXML(t *testing.T) {
+	tests := []struct {
+		name       string
+		xml        string
+		wantMsgId  int64
+		wantStatus string
+	}{
+		{
+			name:      "text message",
+			xml:       `<xml><ToUserName><![CDATA[to]]></ToUserName><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[hi]]></Content><MsgId>1234567890123456</MsgId></xml>`,
+			wantMsgId: 1234567890123456,
+		},
+		{
+			name:       "template job finish",
+			xml:        `<xml><ToUserName><![CDATA[to]]></ToUserName><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[TEMPLATESENDJOBFINISH]]></Event><MsgID>200163836</MsgID><Status><![CDATA[success]]></Status></xml>`,
+			wantMsgId:  200163836,
+			wantStatus: "success",
+		},
+		{
+			name:       "mass job finish",
+			xml:        `<xml><ToUserName><![CDATA[to]]></ToUserName><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[MASSSENDJOBFINISH]]></Event><MsgID>1988</MsgID><Status><![CDATA[send success]]></Status><SentCount>75</SentCount></xml>`,
+			wantMsgId:  1988,
+			wantStatus: "send success",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var event Event
+			if err := xml.Unmarshal([]byte(tt.xml), &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.MsgId != tt.wantMsgId || event.Status != tt.wantStatus || event.ToUser != "to" {
+				t.Errorf("got %+v", event)
+			}
+		})
+	}
+}
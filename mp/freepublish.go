@@ -0,0 +1,231 @@
+package mp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Publish status of PublishStatus.Status
+const (
+	PublishSuccess        = 0
+	PublishPublishing     = 1
+	PublishOriginalFailed = 2 // failed as the article is judged as reprint
+	PublishFailed         = 3
+	PublishAuditFailed    = 4
+	PublishDeletedByUser  = 5 // deleted after published successfully
+	PublishBanned         = 6 // banned after published successfully
+)
+
+// PublishStatus is the result of GetPublishStatus, and the PublishEventInfo of PUBLISHJOBFINISH event.
+type PublishStatus struct {
+	PublishID     string `xml:"publish_id"     json:"publish_id"`
+	Status        int    `xml:"publish_status" json:"publish_status"`
+	ArticleID     string `xml:"article_id"     json:"article_id"` // only valid when succeeded
+	ArticleDetail struct {
+		Count int `xml:"count" json:"count"`
+		Items []struct {
+			Index      int    `xml:"idx"         json:"idx"` // 1 based
+			ArticleURL string `xml:"article_url" json:"article_url"`
+		} `xml:"item" json:"item"`
+	} `xml:"article_detail" json:"article_detail"`
+	FailIndexes []int `xml:"fail_idx" json:"fail_idx"` // 1 based indexes of the failed articles
+}
+
+func (s *PublishStatus) IsFinished() bool {
+	return s.Status != PublishPublishing
+}
+
+type PublishedArticles struct {
+	ArticleID  string `json:"article_id"`
+	UpdateTime int64  `json:"update_time"`
+	Content    struct {
+		Articles []Article `json:"news_item,omitempty"`
+	} `json:"content"`
+}
+
+type PublishedList struct {
+	TotalCount int                 `json:"total_count"`
+	ItemCount  int                 `json:"item_count"` // item count of this time GetPublishedList
+	Items      []PublishedArticles `json:"item"`
+}
+
+// SubmitPublish submits the draft of mediaId to publish. The result is notified by PUBLISHJOBFINISH event,
+// or can be polled by GetPublishStatus.
+func (c *Client) SubmitPublish(mediaId string) (publishId string, msgDataId int64, err error) {
+	u := BASE_URL.Join("/freepublish/submit")
+
+	var req = struct {
+		Id string `json:"media_id"`
+	}{
+		Id: mediaId,
+	}
+
+	var rep struct {
+		Err
+		PublishId string `json:"publish_id"`
+		DataId    int64  `json:"msg_data_id"`
+	}
+
+	err = c.Post(u, &req, &rep)
+	return rep.PublishId, rep.DataId, err
+}
+
+func (c *Client) GetPublishStatus(publishId string) (status *PublishStatus, err error) {
+	u := BASE_URL.Join("/freepublish/get")
+
+	var req = struct {
+		Id string `json:"publish_id"`
+	}{
+		Id: publishId,
+	}
+
+	var rep struct {
+		Err
+		PublishStatus
+	}
+
+	err = c.Post(u, &req, &rep)
+	if err != nil {
+		return
+	}
+
+	status = &rep.PublishStatus
+	return
+}
+
+// PublishAndWait submits the draft of mediaId to publish, and polls the publish status every interval
+// (default 3 seconds) until it is finished or ctx is done. It returns an error if the publishing failed,
+// along with the status.
+func (c *Client) PublishAndWait(ctx context.Context, mediaId string, interval time.Duration) (*PublishStatus, error) {
+	if interval <= 0 {
+		interval = 3 * time.Second
+	}
+
+	publishId, _, err := c.SubmitPublish(mediaId)
+	if err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		status, err := c.GetPublishStatus(publishId)
+		if err != nil {
+			return nil, err
+		}
+		if !status.IsFinished() {
+			continue
+		}
+		if status.Status != PublishSuccess {
+			return status, fmt.Errorf("publish %s failed with status %d, failed articles: %v", publishId, status.Status, status.FailIndexes)
+		}
+		return status, nil
+	}
+}
+
+// GetPublishedArticle gets the articles published successfully.
+func (c *Client) GetPublishedArticle(articleId string) (articles []Article, err error) {
+	u := BASE_URL.Join("/freepublish/getarticle")
+
+	var req = struct {
+		Id string `json:"article_id"`
+	}{
+		Id: articleId,
+	}
+
+	var rep struct {
+		Err
+		Articles []Article `json:"news_item"`
+	}
+
+	err = c.Post(u, &req, &rep)
+	if err != nil {
+		return
+	}
+
+	articles = rep.Articles
+	return
+}
+
+// DeletePublished deletes the index-th(1 based) published article, or all the articles if index is 0.
+func (c *Client) DeletePublished(articleId string, index int) error {
+	u := BASE_URL.Join("/freepublish/delete")
+
+	var req = struct {
+		Id    string `json:"article_id"`
+		Index int    `json:"index,omitempty"`
+	}{
+		Id:    articleId,
+		Index: index,
+	}
+
+	var rep Err
+	return c.Post(u, &req, &rep)
+}
+
+// GetPublishedList gets count published articles from offset. If noContent is true, the Content of the articles are omitted.
+func (c *Client) GetPublishedList(offset, count int, noContent bool) (publishedList *PublishedList, err error) {
+	u := BASE_URL.Join("/freepublish/batchget")
+
+	if count < 1 || count > 20 {
+		err = errors.New("GetPublishedList valid count range is [1,20]")
+		return
+	}
+
+	var req = struct {
+		Offset    int `json:"offset"`
+		Count     int `json:"count"`
+		NoContent int `json:"no_content"`
+	}{
+		Offset: offset,
+		Count:  count,
+	}
+	if noContent {
+		req.NoContent = 1
+	}
+
+	var rep struct {
+		Err
+		PublishedList
+	}
+
+	err = c.Post(u, &req, &rep)
+	if err != nil {
+		return
+	}
+
+	publishedList = &rep.PublishedList
+	return
+}
+
+type PublishedIterator struct {
+	pager
+	items []PublishedArticles
+}
+
+// IteratePublished iterates all published articles with pageSize items per request, DefaultPageSize if pageSize is 0.
+func (c *Client) IteratePublished(pageSize int, noContent bool) *PublishedIterator {
+	it := &PublishedIterator{}
+	it.pager = newPager(pageSize, func(offset, count int) (int, bool, error) {
+		list, err := c.GetPublishedList(offset, count, noContent)
+		if err != nil {
+			return 0, false, err
+		}
+		it.items = list.Items
+		return len(list.Items), offset+len(list.Items) < list.TotalCount, nil
+	})
+	return it
+}
+
+func (it *PublishedIterator) Value() *PublishedArticles {
+	return &it.items[it.index]
+}
@@ -0,0 +1,145 @@
+package mp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Identity is a user across the official accounts and website applications bound to the same Open Platform account,
+// identified by the union ID.
+type Identity struct {
+	UnionID    string            `json:"unionid"`
+	OpenIDs    map[string]string `json:"openids"` // app ID to OpenID
+	Nickname   string            `json:"nickname,omitempty"`
+	HeadImgURL string            `json:"headimgurl,omitempty"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// IdentityStore persists the identities.
+type IdentityStore interface {
+	GetIdentity(unionID string) (*Identity, error)               // returns nil identity if not found
+	GetIdentityByOpenID(appID, openID string) (*Identity, error) // returns nil identity if not found
+	PutIdentity(identity *Identity) error
+}
+
+type MemoryIdentityStore struct {
+	mutex      sync.RWMutex
+	identities map[string]Identity
+	unionIDs   map[string]string // app ID + "/" + OpenID to union ID
+}
+
+func NewMemoryIdentityStore() *MemoryIdentityStore {
+	return &MemoryIdentityStore{
+		identities: make(map[string]Identity),
+		unionIDs:   make(map[string]string),
+	}
+}
+
+func (s *MemoryIdentityStore) GetIdentity(unionID string) (*Identity, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	identity, ok := s.identities[unionID]
+	if !ok {
+		return nil, nil
+	}
+	identity.OpenIDs = copyStringMap(identity.OpenIDs)
+	return &identity, nil
+}
+
+func (s *MemoryIdentityStore) GetIdentityByOpenID(appID, openID string) (*Identity, error) {
+	s.mutex.RLock()
+	unionID, ok := s.unionIDs[appID+"/"+openID]
+	s.mutex.RUnlock()
+
+	if !ok {
+		return nil, nil
+	}
+	return s.GetIdentity(unionID)
+}
+
+func (s *MemoryIdentityStore) PutIdentity(identity *Identity) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored := *identity
+	stored.OpenIDs = copyStringMap(identity.OpenIDs)
+	s.identities[identity.UnionID] = stored
+	for appID, openID := range identity.OpenIDs {
+		s.unionIDs[appID+"/"+openID] = identity.UnionID
+	}
+	return nil
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// Identities links the OpenIDs of the same user in different apps by the union ID,
+// e.g. the official account for H5 pages and the website application for PC.
+type Identities struct {
+	store IdentityStore
+	mutex sync.Mutex
+}
+
+func NewIdentities(store ...IdentityStore) *Identities {
+	ids := &Identities{}
+	if len(store) > 0 {
+		ids.store = store[0]
+	} else {
+		ids.store = NewMemoryIdentityStore()
+	}
+	return ids
+}
+
+// Link records the OpenID of the user in the app of appID into the identity of the user's union ID.
+// The union ID is only available when the app is bound to an Open Platform account,
+// and the user is got with scope snsapi_userinfo or snsapi_login.
+func (ids *Identities) Link(appID string, user *Oauth2User) (*Identity, error) {
+	if user.UnionID == "" {
+		return nil, fmt.Errorf("union id not available for openid %s of app %s", user.OpenID, appID)
+	}
+
+	ids.mutex.Lock()
+	defer ids.mutex.Unlock()
+
+	identity, err := ids.store.GetIdentity(user.UnionID)
+	if err != nil {
+		return nil, err
+	}
+	if identity == nil {
+		identity = &Identity{
+			UnionID: user.UnionID,
+			OpenIDs: make(map[string]string),
+		}
+	}
+
+	identity.OpenIDs[appID] = user.OpenID
+	if user.Nickname != "" {
+		identity.Nickname = user.Nickname
+	}
+	if user.HeadImgURL != "" {
+		identity.HeadImgURL = user.HeadImgURL
+	}
+	identity.UpdatedAt = time.Now()
+
+	if err = ids.store.PutIdentity(identity); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// Resolve returns the identity of the OpenID in the app of appID, or nil if not linked.
+func (ids *Identities) Resolve(appID, openID string) (*Identity, error) {
+	return ids.store.GetIdentityByOpenID(appID, openID)
+}
+
+// Get returns the identity of the union ID, or nil if not linked.
+func (ids *Identities) Get(unionID string) (*Identity, error) {
+	return ids.store.GetIdentity(unionID)
+}
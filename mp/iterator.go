@@ -0,0 +1,68 @@
+package mp
+
+// DefaultPageSize is the page size of the iterators if not specified, which is the maximum of most list APIs.
+const DefaultPageSize = 20
+
+// pager iterates a list page by page. The typed iterators embed it,
+// keep the items of the current page, and return the index-th one as value:
+//
+//	for it.Next() {
+//		item := it.Value()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type pager struct {
+	offset   int
+	pageSize int
+	page     int // items count of the current page
+	index    int // index of the current item in the current page
+	done     bool
+	err      error
+
+	// fetch fetches count items from offset, keeps them, and returns the fetched count and whether there are more items.
+	fetch func(offset, count int) (n int, more bool, err error)
+}
+
+func newPager(pageSize int, fetch func(offset, count int) (n int, more bool, err error)) pager {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	return pager{
+		pageSize: pageSize,
+		fetch:    fetch,
+	}
+}
+
+// Next advances to the next item, fetching the next page if needed.
+// It returns false when no more items or an error occurs.
+func (p *pager) Next() bool {
+	if p.err != nil {
+		return false
+	}
+
+	p.index++
+	if p.index < p.page {
+		return true
+	}
+	if p.done {
+		return false
+	}
+
+	n, more, err := p.fetch(p.offset, p.pageSize)
+	if err != nil {
+		p.err = err
+		return false
+	}
+
+	p.offset += n
+	p.page, p.index = n, 0
+	if n == 0 || !more {
+		p.done = true
+	}
+	return n > 0
+}
+
+// Err returns the error which stops the iteration, if any.
+func (p *pager) Err() error {
+	return p.err
+}
@@ -0,0 +1,80 @@
+package mp
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testIterator iterates the integers of [0, total), failing at the offset failAt if not negative.
+type testIterator struct {
+	pager
+	items []int
+}
+
+func newTestIterator(total, pageSize, failAt int, fetches *[][2]int) *testIterator {
+	it := &testIterator{}
+	it.pager = newPager(pageSize, func(offset, count int) (int, bool, error) {
+		*fetches = append(*fetches, [2]int{offset, count})
+		if offset == failAt {
+			return 0, false, errors.New("fetch failed")
+		}
+		it.items = it.items[:0]
+		for i := offset; i < offset+count && i < total; i++ {
+			it.items = append(it.items, i)
+		}
+		return len(it.items), offset+len(it.items) < total, nil
+	})
+	return it
+}
+
+func (it *testIterator) Value() int {
+	return it.items[it.index]
+}
+
+func TestPager(t *testing.T) {
+	tests := []struct {
+		name     string
+		total    int
+		pageSize int
+		failAt   int
+		want     int // number of items iterated
+		fetches  [][2]int
+		wantErr  bool
+	}{
+		{name: "empty", total: 0, pageSize: 10, failAt: -1, fetches: [][2]int{{0, 10}}},
+		{name: "one partial page", total: 3, pageSize: 10, failAt: -1, want: 3, fetches: [][2]int{{0, 10}}},
+		{name: "full pages", total: 20, pageSize: 10, failAt: -1, want: 20, fetches: [][2]int{{0, 10}, {10, 10}}},
+		{name: "last partial page", total: 25, pageSize: 10, failAt: -1, want: 25, fetches: [][2]int{{0, 10}, {10, 10}, {20, 10}}},
+		{name: "default page size", total: 30, failAt: -1, want: 30, fetches: [][2]int{{0, DefaultPageSize}, {DefaultPageSize, DefaultPageSize}}},
+		{name: "failed", total: 25, pageSize: 10, failAt: 10, want: 10, fetches: [][2]int{{0, 10}, {10, 10}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetches [][2]int
+			it := newTestIterator(tt.total, tt.pageSize, tt.failAt, &fetches)
+
+			n := 0
+			for it.Next() {
+				if v := it.Value(); v != n {
+					t.Fatalf("item %d = %d", n, v)
+				}
+				n++
+			}
+			if it.Next() {
+				t.Error("Next returns true after the end")
+			}
+
+			if n != tt.want {
+				t.Errorf("iterated %d items, want %d", n, tt.want)
+			}
+			if !reflect.DeepEqual(fetches, tt.fetches) {
+				t.Errorf("fetches = %v, want %v", fetches, tt.fetches)
+			}
+			if err := it.Err(); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
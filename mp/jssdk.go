@@ -0,0 +1,179 @@
+package mp
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinTicketRefreshInterval limits how often the tickets are really refreshed by ForceRefreshTicket and ForceRefreshCardTicket.
+var MinTicketRefreshInterval = time.Minute
+
+// JSSDKConfig is the parameter of wx.config.
+type JSSDKConfig struct {
+	Debug       bool     `json:"debug"`
+	AppID       string   `json:"appId"`
+	Timestamp   int64    `json:"timestamp"`
+	NonceStr    string   `json:"nonceStr"`
+	Signature   string   `json:"signature"`
+	JSApiList   []string `json:"jsApiList"`
+	OpenTagList []string `json:"openTagList,omitempty"`
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func jssdkSignature(ticket, nonceStr, timestamp, pageURL string) string {
+	h := sha1.New()
+	h.Write([]byte("jsapi_ticket=" + ticket + "&noncestr=" + nonceStr + "&timestamp=" + timestamp + "&url=" + pageURL))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkJSSDKURL checks the domain of pageURL against JSSDKDomains, and returns pageURL without the fragment.
+func (c *Client) checkJSSDKURL(pageURL string) (string, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid JS-SDK page URL: %s", pageURL)
+	}
+	if len(c.JSSDKDomains) > 0 && !matchHost(u.Hostname(), c.JSSDKDomains) {
+		return "", fmt.Errorf("JS-SDK page URL domain not allowed: %s", pageURL)
+	}
+
+	if i := strings.IndexByte(pageURL, '#'); i >= 0 {
+		pageURL = pageURL[:i]
+	}
+	return pageURL, nil
+}
+
+// JSSDKConfig returns the wx.config parameter for the page of pageURL, with a new nonce and the current timestamp.
+func (c *Client) JSSDKConfig(pageURL string, apiList []string, openTagList ...string) (*JSSDKConfig, error) {
+	pageURL, err := c.checkJSSDKURL(pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ticket, err := c.Ticket()
+	if err != nil {
+		return nil, err
+	}
+	nonceStr, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+
+	return &JSSDKConfig{
+		AppID:       c.appID,
+		Timestamp:   timestamp,
+		NonceStr:    nonceStr,
+		Signature:   jssdkSignature(ticket, nonceStr, strconv.FormatInt(timestamp, 10), pageURL),
+		JSApiList:   apiList,
+		OpenTagList: openTagList,
+	}, nil
+}
+
+// ForceRefreshTicket refreshes the jsapi ticket, e.g. when the page reports an invalid signature.
+// It refreshes at most once per MinTicketRefreshInterval, and returns the current ticket otherwise.
+func (c *Client) ForceRefreshTicket() (string, error) {
+	return c.forceRefreshTicket(TicketJSAPI)
+}
+
+// ForceRefreshCardTicket refreshes the wx_card api_ticket at most once per MinTicketRefreshInterval,
+// and returns the current ticket otherwise.
+func (c *Client) ForceRefreshCardTicket() (string, error) {
+	return c.forceRefreshTicket(TicketCard)
+}
+
+func (c *Client) forceRefreshTicket(ticketType string) (string, error) {
+	c.ticketMutex.Lock()
+	if time.Since(c.ticketRefreshedAt[ticketType]) < MinTicketRefreshInterval {
+		c.ticketMutex.Unlock()
+		return c.TicketOf(ticketType)
+	}
+	if c.ticketRefreshedAt == nil {
+		c.ticketRefreshedAt = make(map[string]time.Time)
+	}
+	c.ticketRefreshedAt[ticketType] = time.Now()
+	c.ticketMutex.Unlock()
+
+	return c.RefreshTicketOf(ticketType, "")
+}
+
+// CardExt is the cardExt of wx.addCard, which should be JSON encoded.
+type CardExt struct {
+	Code      string `json:"code,omitempty"`
+	OpenID    string `json:"openid,omitempty"`
+	Timestamp string `json:"timestamp"`
+	NonceStr  string `json:"nonce_str"`
+	Signature string `json:"signature"`
+	OuterStr  string `json:"outer_str,omitempty"` // scene value returned in the user_get_card event
+}
+
+// CardExt returns the cardExt of wx.addCard. code is only needed for cards of custom code,
+// and openID is only needed for cards which can only be received by the specified user.
+func (c *Client) CardExt(cardID, code, openID string) (*CardExt, error) {
+	ticket, err := c.CardTicket()
+	if err != nil {
+		return nil, err
+	}
+	nonceStr, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	return &CardExt{
+		Code:      code,
+		OpenID:    openID,
+		Timestamp: timestamp,
+		NonceStr:  nonceStr,
+		Signature: computeSign(ticket, timestamp, nonceStr, cardID, code, openID),
+	}, nil
+}
+
+// ChooseCardConfig is the parameter of wx.chooseCard.
+type ChooseCardConfig struct {
+	ShopID    string `json:"shopId,omitempty"`
+	CardType  string `json:"cardType,omitempty"`
+	CardID    string `json:"cardId,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+	NonceStr  string `json:"nonceStr"`
+	SignType  string `json:"signType"`
+	CardSign  string `json:"cardSign"`
+}
+
+// ChooseCardConfig returns the parameter of wx.chooseCard. The filters shopID, cardType and cardID may be empty.
+func (c *Client) ChooseCardConfig(shopID, cardType, cardID string) (*ChooseCardConfig, error) {
+	ticket, err := c.CardTicket()
+	if err != nil {
+		return nil, err
+	}
+	nonceStr, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+
+	return &ChooseCardConfig{
+		ShopID:    shopID,
+		CardType:  cardType,
+		CardID:    cardID,
+		Timestamp: timestamp,
+		NonceStr:  nonceStr,
+		SignType:  "SHA1",
+		CardSign:  computeSign(ticket, c.appID, shopID, strconv.FormatInt(timestamp, 10), nonceStr, cardID, cardType),
+	}, nil
+}
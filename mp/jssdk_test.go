@@ -0,0 +1,87 @@
+package mp
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestJSSDKSignature(t *testing.T) {
+	tests := []struct {
+		name      string
+		ticket    string
+		nonceStr  string
+		timestamp string
+		pageURL   string
+		want      string
+	}{
+		{
+			// the example of the JS-SDK document
+			name:      "document example",
+			ticket:    "sM4AOVdWfPE4DxkXGEs8VMCPGGVi4C3VM0P37wVUCFvkVAy_90u5h9nbSlYy3-Sl-HhTdfl2fzFy1AOcHKP7qg",
+			nonceStr:  "Wm3WZYTPz0wzccnW",
+			timestamp: "1414587457",
+			pageURL:   "http://mp.weixin.qq.com?params=value",
+			want:      "0f9de62fce790f9a083d5c99e95740ceb90c27ed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jssdkSignature(tt.ticket, tt.nonceStr, tt.timestamp, tt.pageURL); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckJSSDKURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains []string
+		pageURL string
+		want    string // empty if not allowed
+	}{
+		{name: "any domain", pageURL: "https://example.com/page?a=b", want: "https://example.com/page?a=b"},
+		{name: "fragment removed", pageURL: "https://example.com/page?a=b#/route", want: "https://example.com/page?a=b"},
+		{name: "not http", pageURL: "ftp://example.com/page"},
+		{name: "domain allowed", domains: []string{"example.com"}, pageURL: "https://EXAMPLE.com/", want: "https://EXAMPLE.com/"},
+		{name: "subdomain allowed", domains: []string{"*.example.com"}, pageURL: "https://m.example.com/", want: "https://m.example.com/"},
+		{name: "domain not allowed", domains: []string{"example.com"}, pageURL: "https://evil.com/?example.com"},
+		{name: "suffix not allowed", domains: []string{"*.example.com"}, pageURL: "https://evilexample.com/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient("appid", "secret", false)
+			c.JSSDKDomains = tt.domains
+
+			got, err := c.checkJSSDKURL(tt.pageURL)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("got %s, want error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSSDKConfig(t *testing.T) {
+	c := NewClient("appid", "secret", false)
+	ticket := c.ticket(TicketJSAPI)
+	ticket.mutex.Lock()
+	ticket.store("ticket", 7200)
+	ticket.mutex.Unlock()
+
+	config, err := c.JSSDKConfig("https://example.com/page#hash", []string{"updateAppMessageShareData"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := jssdkSignature("ticket", config.NonceStr, strconv.FormatInt(config.Timestamp, 10), "https://example.com/page")
+	if config.AppID != "appid" || config.NonceStr == "" || config.Signature != want {
+		t.Errorf("got %+v, want signature %s", config, want)
+	}
+}
@@ -0,0 +1,436 @@
+package mp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	MaxMassUsers = 10000 // maximum OpenIDs of one mass message sent by users
+	MinMassUsers = 2     // minimum OpenIDs of one mass message sent by users
+)
+
+// MassMsg is the content of a mass message. Only the field of MsgType is used.
+type MassMsg struct {
+	MsgType string   `json:"msgtype"`
+	Text    *Text    `json:"text,omitempty"`
+	Image   *Image   `json:"image,omitempty"`
+	Voice   *Voice   `json:"voice,omitempty"`
+	Video   *MPVideo `json:"mpvideo,omitempty"`
+	News    *MPNews  `json:"mpnews,omitempty"`
+	Card    *Card    `json:"wxcard,omitempty"`
+}
+
+type MassSendOptions struct {
+	ClientMsgID       string // dedup key in 24 hours, no more than 64 bytes
+	SendIgnoreReprint bool   // for MsgMPNews, whether to continue sending when the article is judged as reprint
+}
+
+// SendMassByUsers sends the mass message to the users, who should be no less than MinMassUsers
+// and no more than MaxMassUsers. A retry with the same ClientMsgID will not send the message again,
+// but fails with ClientMsgIDExist.
+func (c *Client) SendMassByUsers(msg *MassMsg, userIds []string, opts ...*MassSendOptions) (id, dataId int64, err error) {
+	if n := len(userIds); n < MinMassUsers || n > MaxMassUsers {
+		err = fmt.Errorf("mass message users num should be in range [%d,%d]: %d", MinMassUsers, MaxMassUsers, n)
+		return
+	}
+
+	var req = struct {
+		*MassMsg
+		ToUsers           []string `json:"touser"`
+		ClientMsgID       string   `json:"clientmsgid,omitempty"`
+		SendIgnoreReprint int      `json:"send_ignore_reprint,omitempty"`
+	}{
+		MassMsg: msg,
+		ToUsers: userIds,
+	}
+	if len(opts) > 0 && opts[0] != nil {
+		req.ClientMsgID = opts[0].ClientMsgID
+		if opts[0].SendIgnoreReprint {
+			req.SendIgnoreReprint = 1
+		}
+	}
+
+	var rep struct {
+		Err
+		Id     int64 `json:"msg_id"`
+		DataId int64 `json:"msg_data_id"` // only exists for MsgMPNews
+	}
+
+	err = c.Post(BASE_URL.Join("/message/mass/send"), &req, &rep)
+	return rep.Id, rep.DataId, err
+}
+
+// Status of mass chunks.
+const (
+	MassChunkPending = ""        // not sent yet, or failed to send with a transient error
+	MassChunkSending = "sending" // sent, waiting for the job finish
+	MassChunkSuccess = "success"
+	MassChunkFailed  = "failed"  // failed to send with a non-retryable error, or the job failed
+	MassChunkUnknown = "unknown" // sent by a previous run whose msg_id was lost, the result cannot be tracked
+)
+
+// MassChunk is a part of the recipients of a mass job, sent as one mass message.
+type MassChunk struct {
+	Index       int      `json:"index"`
+	ClientMsgID string   `json:"clientmsgid"`
+	UserIds     []string `json:"touser"`
+
+	Status    string `json:"status,omitempty"`
+	MsgID     int64  `json:"msg_id,omitempty"`
+	MsgDataID int64  `json:"msg_data_id,omitempty"`
+	ErrCode   int    `json:"errcode,omitempty"`
+	ErrMsg    string `json:"errmsg,omitempty"` // error of sending, or the status of MASSSENDJOBFINISH
+
+	TotalCount  int `json:"total_count,omitempty"`
+	FilterCount int `json:"filter_count,omitempty"`
+	SentCount   int `json:"sent_count,omitempty"`
+	ErrorCount  int `json:"error_count,omitempty"`
+}
+
+func (chunk *MassChunk) IsFinished() bool {
+	return chunk.Status == MassChunkSuccess || chunk.Status == MassChunkFailed || chunk.Status == MassChunkUnknown
+}
+
+// MassJob sends a mass message to a large list of users, split into chunks.
+type MassJob struct {
+	ID                string       `json:"id"`
+	Msg               MassMsg      `json:"msg"`
+	SendIgnoreReprint bool         `json:"send_ignore_reprint,omitempty"`
+	Chunks            []*MassChunk `json:"chunks"`
+}
+
+// NewMassJob splits the users into chunks of no more than chunkSize (default MaxMassUsers).
+// The users are spread evenly over the chunks, so that no chunk is smaller than MinMassUsers;
+// with chunkSize MinMassUsers and an odd number of users, one chunk has an extra user.
+// The client message ID of each chunk is derived from the job ID, which should be unique in 24 hours.
+func NewMassJob(id string, msg *MassMsg, userIds []string, chunkSize ...int) (*MassJob, error) {
+	size := MaxMassUsers
+	if len(chunkSize) > 0 {
+		size = chunkSize[0]
+		if size < MinMassUsers || size > MaxMassUsers {
+			return nil, fmt.Errorf("mass chunk size should be in range [%d,%d]: %d", MinMassUsers, MaxMassUsers, size)
+		}
+	}
+	n := len(userIds)
+	if n < MinMassUsers {
+		return nil, fmt.Errorf("mass job users num too small: %d", n)
+	}
+
+	count := (n + size - 1) / size
+	if count > 1 && n/count < MinMassUsers {
+		count-- // the chunks grow by at most one user, as size is MinMassUsers here
+	}
+
+	job := &MassJob{
+		ID:     id,
+		Msg:    *msg,
+		Chunks: make([]*MassChunk, count),
+	}
+	start := 0
+	for i := range job.Chunks {
+		end := start + n/count
+		if i < n%count {
+			end++
+		}
+		job.Chunks[i] = &MassChunk{
+			Index:       i,
+			ClientMsgID: fmt.Sprintf("%s-%d", id, i),
+			UserIds:     userIds[start:end],
+		}
+		start = end
+	}
+
+	if len(job.Chunks[count-1].ClientMsgID) > 64 {
+		return nil, fmt.Errorf("mass job id too long: %s", id)
+	}
+	return job, nil
+}
+
+type MassTotals struct {
+	Chunks   int // number of chunks
+	Finished int // number of finished chunks
+	Total    int // TotalCount of finished chunks
+	Sent     int
+	Filtered int
+	Errored  int
+}
+
+func (job *MassJob) Totals() *MassTotals {
+	totals := &MassTotals{Chunks: len(job.Chunks)}
+	for _, chunk := range job.Chunks {
+		if !chunk.IsFinished() {
+			continue
+		}
+		totals.Finished++
+		totals.Total += chunk.TotalCount
+		totals.Sent += chunk.SentCount
+		totals.Filtered += chunk.FilterCount
+		totals.Errored += chunk.ErrorCount
+	}
+	return totals
+}
+
+func (job *MassJob) IsFinished() bool {
+	for _, chunk := range job.Chunks {
+		if !chunk.IsFinished() {
+			return false
+		}
+	}
+	return true
+}
+
+// MassJobStore persists mass jobs.
+type MassJobStore interface {
+	SaveMassJob(job *MassJob) error
+	LoadMassJob(id string) (*MassJob, error)          // returns nil job if not found
+	LoadMassJobByMsgID(msgID int64) (*MassJob, error) // returns nil job if not found
+}
+
+type MemoryMassJobStore struct {
+	mutex  sync.RWMutex
+	jobs   map[string]*MassJob
+	msgIDs map[int64]string
+}
+
+func NewMemoryMassJobStore() *MemoryMassJobStore {
+	return &MemoryMassJobStore{
+		jobs:   make(map[string]*MassJob),
+		msgIDs: make(map[int64]string),
+	}
+}
+
+func (s *MemoryMassJobStore) SaveMassJob(job *MassJob) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.jobs[job.ID] = job
+	for _, chunk := range job.Chunks {
+		if chunk.MsgID != 0 {
+			s.msgIDs[chunk.MsgID] = job.ID
+		}
+	}
+	return nil
+}
+
+func (s *MemoryMassJobStore) LoadMassJob(id string) (*MassJob, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.jobs[id], nil
+}
+
+func (s *MemoryMassJobStore) LoadMassJobByMsgID(msgID int64) (*MassJob, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	id, ok := s.msgIDs[msgID]
+	if !ok {
+		return nil, nil
+	}
+	return s.jobs[id], nil
+}
+
+// MassSender runs mass jobs and tracks their completion. Register HandleJobFinish on the server:
+//
+//	srv.HandleEvent(mp.EventMassSendJobFinish, sender.HandleJobFinish)
+type MassSender struct {
+	client *Client
+	store  MassJobStore
+	mutex  sync.Mutex
+
+	OnFinish func(job *MassJob, chunk *MassChunk) // called when a chunk is finished
+}
+
+func NewMassSender(client *Client, store ...MassJobStore) *MassSender {
+	s := &MassSender{
+		client: client,
+	}
+	if len(store) > 0 {
+		s.store = store[0]
+	} else {
+		s.store = NewMemoryMassJobStore()
+	}
+	return s
+}
+
+// Run sends the pending chunks of the job, and saves the job after each chunk.
+// It is safe to run the same job again after failure: the sent chunks are skipped,
+// and the client message IDs prevent a chunk from being sent twice.
+// A chunk failed with a transient error is kept pending for the next run,
+// while a chunk failed with an error of the request or the users is marked failed.
+func (s *MassSender) Run(job *MassJob) error {
+	// the status of the chunks is changed by HandleJobFinish, so only read with mutex held
+	var pending []*MassChunk
+	s.mutex.Lock()
+	for _, chunk := range job.Chunks {
+		if chunk.Status == MassChunkPending {
+			pending = append(pending, chunk)
+		}
+	}
+	err := s.store.SaveMassJob(job)
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	opts := &MassSendOptions{SendIgnoreReprint: job.SendIgnoreReprint}
+
+	var lastErr error
+	for _, chunk := range pending {
+		opts.ClientMsgID = chunk.ClientMsgID
+		id, dataId, err := s.client.SendMassByUsers(&job.Msg, chunk.UserIds, opts)
+
+		// not locked while sending, as HandleJobFinish may be called for the sent chunks meanwhile
+		s.mutex.Lock()
+		if err = s.sent(job, chunk, id, dataId, err); err != nil {
+			lastErr = err
+		}
+		err = s.store.SaveMassJob(job)
+		s.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return lastErr
+}
+
+// sent updates the chunk by the result of sending, and returns the error which fails the chunk.
+func (s *MassSender) sent(job *MassJob, chunk *MassChunk, id, dataId int64, err error) error {
+	s.mergeFinished(job)
+
+	if e, ok := err.(Error); ok && e.Code() == ClientMsgIDExist {
+		err = nil // sent by the previous run
+		if id == 0 && chunk.MsgID == 0 {
+			chunk.Status = MassChunkUnknown
+			chunk.ErrCode, chunk.ErrMsg = e.Code(), e.Msg()
+			return nil
+		}
+	}
+	if id != 0 {
+		chunk.MsgID, chunk.MsgDataID = id, dataId
+	}
+	if err != nil {
+		chunk.ErrCode, chunk.ErrMsg = errCodeMsg(err)
+		if !isTransientMassErr(err) {
+			chunk.Status = MassChunkFailed
+		}
+		return err
+	}
+
+	chunk.Status = MassChunkSending
+	chunk.ErrCode, chunk.ErrMsg = OK, ""
+	return nil
+}
+
+// mergeFinished copies the chunks finished by HandleJobFinish into job, in case the store
+// does not return the same job, so that saving job does not overwrite them.
+func (s *MassSender) mergeFinished(job *MassJob) {
+	stored, err := s.store.LoadMassJob(job.ID)
+	if err != nil || stored == nil || stored == job || len(stored.Chunks) != len(job.Chunks) {
+		return
+	}
+	for i, chunk := range stored.Chunks {
+		if chunk.IsFinished() && !job.Chunks[i].IsFinished() {
+			*job.Chunks[i] = *chunk
+		}
+	}
+}
+
+// isTransientMassErr reports whether a chunk failed with err may be sent by the next run.
+// Errors without errcode are transient, as the client message ID prevents sending twice.
+func isTransientMassErr(err error) bool {
+	e, ok := err.(Error)
+	if !ok {
+		return true
+	}
+	switch ErrClassOf(e.Code()) {
+	case ErrClassRetryable, ErrClassQuota, ErrClassAuth:
+		return true
+	}
+	return false
+}
+
+func errCodeMsg(err error) (int, string) {
+	if e, ok := err.(Error); ok {
+		return e.Code(), e.Msg()
+	}
+	return OK, err.Error()
+}
+
+func (s *MassSender) HandleJobFinish(ctx *Context) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, err := s.store.LoadMassJobByMsgID(ctx.MsgId)
+	if err != nil || job == nil {
+		return
+	}
+
+	for _, chunk := range job.Chunks {
+		if chunk.MsgID != ctx.MsgId {
+			continue
+		}
+
+		if ctx.Status == "send success" {
+			chunk.Status = MassChunkSuccess
+		} else {
+			chunk.Status = MassChunkFailed
+		}
+		chunk.ErrMsg = ctx.Status
+		chunk.TotalCount = ctx.TotalCount
+		chunk.FilterCount = ctx.FilterCount
+		chunk.SentCount = ctx.SentCount
+		chunk.ErrorCount = ctx.ErrorCount
+
+		if s.store.SaveMassJob(job) == nil && s.OnFinish != nil {
+			s.OnFinish(job, chunk)
+		}
+		return
+	}
+}
+
+// Reconcile queries the status of the chunks which are sent but not finished,
+// in case the MASSSENDJOBFINISH events are lost. Note the counts of the chunks
+// are only available from the events.
+func (s *MassSender) Reconcile(jobID string) (*MassJob, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, err := s.store.LoadMassJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, fmt.Errorf("mass job not found: %s", jobID)
+	}
+
+	changed := false
+	for _, chunk := range job.Chunks {
+		if chunk.Status != MassChunkSending || chunk.MsgID == 0 {
+			continue
+		}
+
+		status, err := s.client.GetMsgStatus(chunk.MsgID)
+		if err != nil {
+			return job, err
+		}
+
+		switch status {
+		case MsgStatusSendSuccess:
+			chunk.Status = MassChunkSuccess
+		case MsgStatusSendFail, MsgStatusDelete:
+			chunk.Status = MassChunkFailed
+		default:
+			continue
+		}
+		chunk.ErrMsg = strings.ToLower(status)
+		changed = true
+	}
+
+	if changed {
+		err = s.store.SaveMassJob(job)
+	}
+	return job, err
+}
@@ -0,0 +1,128 @@
+package mp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func testUserIds(n int) []string {
+	userIds := make([]string, n)
+	for i := range userIds {
+		userIds[i] = fmt.Sprintf("user%d", i)
+	}
+	return userIds
+}
+
+func TestNewMassJob(t *testing.T) {
+	tests := []struct {
+		name   string
+		users  int
+		size   []int
+		sizes  []int // sizes of the chunks
+		errMsg string
+	}{
+		{name: "default size", users: 3, sizes: []int{3}},
+		{name: "exact chunks", users: 6, size: []int{3}, sizes: []int{3, 3}},
+		{name: "remainder spread", users: 7, size: []int{3}, sizes: []int{3, 2, 2}},
+		{name: "remainder of one", users: 10001, sizes: []int{5001, 5000}},
+		{name: "size 2 with odd users", users: 5, size: []int{2}, sizes: []int{3, 2}},
+		{name: "size 2 with 3 users", users: 3, size: []int{2}, sizes: []int{3}},
+		{name: "size 2 with even users", users: 4, size: []int{2}, sizes: []int{2, 2}},
+		{name: "too few users", users: 1, errMsg: "too small"},
+		{name: "size too small", users: 5, size: []int{1}, errMsg: "chunk size"},
+		{name: "size too large", users: 5, size: []int{MaxMassUsers + 1}, errMsg: "chunk size"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userIds := testUserIds(tt.users)
+			job, err := NewMassJob("job", &MassMsg{MsgType: MsgText}, userIds, tt.size...)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("err = %v, want containing %q", err, tt.errMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(job.Chunks) != len(tt.sizes) {
+				t.Fatalf("got %d chunks, want %v", len(job.Chunks), tt.sizes)
+			}
+			var all []string
+			for i, chunk := range job.Chunks {
+				if len(chunk.UserIds) != tt.sizes[i] {
+					t.Errorf("chunk %d has %d users, want %d", i, len(chunk.UserIds), tt.sizes[i])
+				}
+				if chunk.Index != i || chunk.ClientMsgID != fmt.Sprintf("job-%d", i) {
+					t.Errorf("chunk %d has index %d and clientmsgid %s", i, chunk.Index, chunk.ClientMsgID)
+				}
+				all = append(all, chunk.UserIds...)
+			}
+			if strings.Join(all, ",") != strings.Join(userIds, ",") {
+				t.Errorf("chunks do not cover the users in order")
+			}
+		})
+	}
+}
+
+func TestNewMassJobIDTooLong(t *testing.T) {
+	// the 64 bytes limit is checked against the last chunk, e.g. "-10", not "-0" of the first chunk
+	id := strings.Repeat("a", 62)
+	if _, err := NewMassJob(id, &MassMsg{MsgType: MsgText}, testUserIds(9), 2); err != nil {
+		t.Fatalf("4 chunks: %v", err)
+	}
+	if _, err := NewMassJob(id, &MassMsg{MsgType: MsgText}, testUserIds(22), 2); err == nil {
+		t.Fatal("11 chunks: want id too long error")
+	}
+}
+
+func TestMassSenderRun(t *testing.T) {
+	// the response of each chunk by the clientmsgid
+	responses := map[string]string{
+		"job-0": `{"errcode":0,"errmsg":"send job submission success","msg_id":1000,"msg_data_id":2000}`,
+		"job-1": `{"errcode":-1,"errmsg":"system error"}`,
+		"job-2": `{"errcode":40003,"errmsg":"invalid openid"}`,
+		"job-3": `{"errcode":45065,"errmsg":"clientmsgid exist"}`,
+	}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ClientMsgID string `json:"clientmsgid"`
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &req)
+		w.Write([]byte(responses[req.ClientMsgID]))
+	})
+	maxBusyRetries := MaxBusyRetries
+	MaxBusyRetries = 0
+	defer func() { MaxBusyRetries = maxBusyRetries }()
+
+	job, err := NewMassJob("job", &MassMsg{MsgType: MsgText, Text: &Text{Content: "hi"}}, testUserIds(8), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := NewMassSender(c)
+	if err = sender.Run(job); err == nil {
+		t.Fatal("want error of the failed chunks")
+	}
+
+	want := []string{MassChunkSending, MassChunkPending, MassChunkFailed, MassChunkUnknown}
+	for i, chunk := range job.Chunks {
+		if chunk.Status != want[i] {
+			t.Errorf("chunk %d status = %q, want %q", i, chunk.Status, want[i])
+		}
+	}
+	if job.Chunks[0].MsgID != 1000 || job.Chunks[0].MsgDataID != 2000 {
+		t.Errorf("chunk 0 msg_id = %d, msg_data_id = %d", job.Chunks[0].MsgID, job.Chunks[0].MsgDataID)
+	}
+
+	sender.HandleJobFinish(&Context{Event: &Event{MsgId: 1000, Status: "send success", SentCount: 2}})
+	if job.Chunks[0].Status != MassChunkSuccess || job.Chunks[0].SentCount != 2 {
+		t.Errorf("chunk 0 = %+v after job finish", job.Chunks[0])
+	}
+}
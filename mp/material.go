@@ -2,8 +2,10 @@ package mp
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/kataras/go-errors"
+	"io"
+	"os"
+	"path/filepath"
 )
 
 const (
@@ -46,6 +48,12 @@ type Article struct {
 	ShowCoverPic     int    `json:"show_cover_pic"`               // whether show cover picture
 	ThumbURL         string `json:"thumb_url"`                    // cover picture URL, only valid for GetNews
 	URL              string `json:"url"`                          // content URL, only valid for GetNews
+
+	NeedOpenComment    int    `json:"need_open_comment,omitempty"`     // whether open comment
+	OnlyFansCanComment int    `json:"only_fans_can_comment,omitempty"` // whether only fans can comment
+	PicCrop235_1       string `json:"pic_crop_235_1,omitempty"`        // crop coordinates of the 2.35:1 cover, only valid for drafts
+	PicCrop1_1         string `json:"pic_crop_1_1,omitempty"`          // crop coordinates of the 1:1 cover, only valid for drafts
+	IsDeleted          bool   `json:"is_deleted,omitempty"`            // only valid for GetPublishedArticle
 }
 
 type News struct {
@@ -59,16 +67,18 @@ type MediaCounts struct {
 	NewsCount  int `json:"news_count"`
 }
 
+type NewsItem struct {
+	Id         string `json:"media_id"`
+	UpdateTime int64  `json:"update_time"`
+	Content    struct {
+		Articles []Article `json:"news_item,omitempty"`
+	} `json:"content"`
+}
+
 type NewsList struct {
-	TotalCount int `json:"total_count"`
-	ItemCount  int `json:"item_count"` // item count of this time GetNewsList
-	Items      []struct {
-		Id         string `json:"media_id"`
-		UpdateTime int64  `json:"update_time"`
-		Content    struct {
-			Articles []Article `json:"news_item,omitempty"`
-		} `json:"content"`
-	} `json:"item"`
+	TotalCount int        `json:"total_count"`
+	ItemCount  int        `json:"item_count"` // item count of this time GetNewsList
+	Items      []NewsItem `json:"item"`
 }
 
 type MediaList struct {
@@ -94,6 +104,18 @@ func (c *Client) UploadTempThumb(filePath string) (*TempMedia, error) {
 }
 
 func (c *Client) UploadTempMedia(mediaType, filePath string) (*TempMedia, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return c.UploadTempMediaFromReader(mediaType, file, filepath.Base(filePath), "")
+}
+
+// UploadTempMediaFromReader uploads the content of reader as temporary media named fileName.
+// contentType may be empty.
+func (c *Client) UploadTempMediaFromReader(mediaType string, reader io.Reader, fileName, contentType string) (*TempMedia, error) {
 	u := BASE_URL.Join("/media/upload").Query("type", mediaType)
 
 	var rep struct {
@@ -101,7 +123,7 @@ func (c *Client) UploadTempMedia(mediaType, filePath string) (*TempMedia, error)
 		TempMedia
 	}
 
-	err := c.UploadFile(u, "media", filePath, nil, &rep)
+	err := c.UploadFromReader(u, "media", reader, fileName, contentType, nil, &rep)
 	if err != nil {
 		return nil, err
 	}
@@ -117,6 +139,14 @@ func (c *Client) DownloadTempMedia(mediaId, filePath string) error {
 	return c.DownloadFile(u, nil, filePath, &rep)
 }
 
+func (c *Client) DownloadTempMediaToWriter(mediaId string, w io.Writer) error {
+	u := BASE_URL.Join("/media/get").Query("media_id", mediaId)
+
+	var rep Err
+
+	return c.DownloadToWriter(u, nil, w, &rep)
+}
+
 func (c *Client) UploadImage(filePath string) (*Media, error) {
 	return c.UploadMedia(MediaImage, filePath)
 }
@@ -130,6 +160,24 @@ func (c *Client) UploadVoice(filePath string) (*Media, error) {
 }
 
 func (c *Client) UploadVideo(title, intro, filePath string) (*Media, error) {
+	extraFields, err := videoDescription(title, intro)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.UploadMedia(MediaVideo, filePath, extraFields)
+}
+
+func (c *Client) UploadVideoFromReader(title, intro string, reader io.Reader, fileName, contentType string) (*Media, error) {
+	extraFields, err := videoDescription(title, intro)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.UploadMediaFromReader(MediaVideo, reader, fileName, contentType, extraFields)
+}
+
+func videoDescription(title, intro string) (map[string]string, error) {
 	var descr = struct {
 		Title string `json:"title"`
 		Intro string `json:"introduction"`
@@ -143,14 +191,24 @@ func (c *Client) UploadVideo(title, intro, filePath string) (*Media, error) {
 		return nil, err
 	}
 
-	extraFields := map[string]string{
+	return map[string]string{
 		"description": string(description),
+	}, nil
+}
+
+func (c *Client) UploadMedia(mediaType, filePath string, extraFields ...map[string]string) (*Media, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
 	}
+	defer file.Close()
 
-	return c.UploadMedia(MediaVideo, filePath, extraFields)
+	return c.UploadMediaFromReader(mediaType, file, filepath.Base(filePath), "", extraFields...)
 }
 
-func (c *Client) UploadMedia(mediaType, filePath string, extraFields ...map[string]string) (*Media, error) {
+// UploadMediaFromReader uploads the content of reader as permanent media named fileName.
+// contentType may be empty.
+func (c *Client) UploadMediaFromReader(mediaType string, reader io.Reader, fileName, contentType string, extraFields ...map[string]string) (*Media, error) {
 	u := BASE_URL.Join("/material/add_material").Query("type", mediaType)
 
 	var rep struct {
@@ -163,7 +221,7 @@ func (c *Client) UploadMedia(mediaType, filePath string, extraFields ...map[stri
 		fields = extraFields[0]
 	}
 
-	err := c.UploadFile(u, "media", filePath, fields, &rep)
+	err := c.UploadFromReader(u, "media", reader, fileName, contentType, fields, &rep)
 	if err != nil {
 		return nil, err
 	}
@@ -206,6 +264,18 @@ func (c *Client) DownloadVideo(mediaId, filePath string) (err error) {
 	return
 }
 
+func (c *Client) DownloadVideoToWriter(mediaId string, w io.Writer) (err error) {
+	video, err := c.GetVideo(mediaId)
+	if err != nil {
+		return
+	}
+
+	var rep Err
+
+	err = c.DownloadToWriter(URL(video.URL), nil, w, &rep)
+	return
+}
+
 func (c *Client) DownloadMedia(mediaId, filePath string) (err error) {
 	u := BASE_URL.Join("/material/get_material")
 
@@ -221,6 +291,50 @@ func (c *Client) DownloadMedia(mediaId, filePath string) (err error) {
 	return
 }
 
+func (c *Client) DownloadMediaToWriter(mediaId string, w io.Writer) (err error) {
+	u := BASE_URL.Join("/material/get_material")
+
+	var req = struct {
+		Id string `json:"media_id"`
+	}{
+		Id: mediaId,
+	}
+
+	var rep Err
+
+	err = c.DownloadToWriter(u, &req, w, &rep)
+	return
+}
+
+// UploadArticleImage uploads the image(jpg or png, less than 1MB) used in the content of articles,
+// and returns its URL. It does not occupy the permanent media quota.
+func (c *Client) UploadArticleImage(filePath string) (url string, err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return c.UploadArticleImageFromReader(file, filepath.Base(filePath), "")
+}
+
+func (c *Client) UploadArticleImageFromReader(reader io.Reader, fileName, contentType string) (url string, err error) {
+	u := BASE_URL.Join("/media/uploadimg")
+
+	var rep struct {
+		Err
+		URL string `json:"url"`
+	}
+
+	err = c.UploadFromReader(u, "media", reader, fileName, contentType, nil, &rep)
+	if err != nil {
+		return
+	}
+
+	url = rep.URL
+	return
+}
+
 func (c *Client) CreateNews(news *News) (mediaId string, err error) {
 	u := BASE_URL.Join("/material/add_news")
 
@@ -286,7 +400,7 @@ func (c *Client) GetMediaCounts() (mediaCounts *MediaCounts, err error) {
 
 	var rep struct {
 		Err
-		Counts MediaCounts
+		MediaCounts
 	}
 
 	err = c.Get(u, &rep)
@@ -294,7 +408,7 @@ func (c *Client) GetMediaCounts() (mediaCounts *MediaCounts, err error) {
 		return
 	}
 
-	mediaCounts = &rep.Counts
+	mediaCounts = &rep.MediaCounts
 	return
 }
 
@@ -333,11 +447,6 @@ func (c *Client) GetNewsList(offset, count int) (newsList *NewsList, err error)
 func (c *Client) GetMediaList(mediaType string, offset, count int) (mediaList *MediaList, err error) {
 	u := BASE_URL.Join("/material/batchget_material")
 
-	if mediaType == MediaVideo {
-		err = fmt.Errorf("GetMediaList does not support mediaType '%s'", MediaVideo)
-		return
-	}
-
 	if count < 1 || count > 20 {
 		err = errors.New("GetMediaList valid count range is [1,20]")
 		return
@@ -381,3 +490,49 @@ func (c *Client) DeleteMedia(mediaId string) (err error) {
 	err = c.Post(u, &req, &rep)
 	return
 }
+
+type NewsIterator struct {
+	pager
+	items []NewsItem
+}
+
+// IterateNews iterates all permanent news with pageSize news per request, DefaultPageSize if pageSize is 0.
+func (c *Client) IterateNews(pageSize int) *NewsIterator {
+	it := &NewsIterator{}
+	it.pager = newPager(pageSize, func(offset, count int) (int, bool, error) {
+		list, err := c.GetNewsList(offset, count)
+		if err != nil {
+			return 0, false, err
+		}
+		it.items = list.Items
+		return len(list.Items), offset+len(list.Items) < list.TotalCount, nil
+	})
+	return it
+}
+
+func (it *NewsIterator) Value() *NewsItem {
+	return &it.items[it.index]
+}
+
+type MediaIterator struct {
+	pager
+	items []Media
+}
+
+// IterateMedia iterates all permanent media of mediaType with pageSize media per request, DefaultPageSize if pageSize is 0.
+func (c *Client) IterateMedia(mediaType string, pageSize int) *MediaIterator {
+	it := &MediaIterator{}
+	it.pager = newPager(pageSize, func(offset, count int) (int, bool, error) {
+		list, err := c.GetMediaList(mediaType, offset, count)
+		if err != nil {
+			return 0, false, err
+		}
+		it.items = list.Items
+		return len(list.Items), offset+len(list.Items) < list.TotalCount, nil
+	})
+	return it
+}
+
+func (it *MediaIterator) Value() *Media {
+	return &it.items[it.index]
+}
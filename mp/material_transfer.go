@@ -0,0 +1,382 @@
+package mp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	MaterialManifestFile = "manifest.json" // manifest of the exported materials
+	MaterialImportFile   = "import.json"   // progress of importing the exported materials
+)
+
+// ExportedMedia is a permanent media file in the export directory.
+type ExportedMedia struct {
+	Type        string `json:"type"`
+	Id          string `json:"media_id"`
+	Name        string `json:"name,omitempty"`
+	URL         string `json:"url,omitempty"`
+	UpdateTime  int64  `json:"update_time,omitempty"`
+	File        string `json:"file"`                  // slash separated path relative to the export directory
+	Title       string `json:"title,omitempty"`       // only for video
+	Description string `json:"description,omitempty"` // only for video
+}
+
+type ExportedNews struct {
+	Id         string    `json:"media_id"`
+	UpdateTime int64     `json:"update_time"`
+	Articles   []Article `json:"articles"`
+}
+
+type MaterialManifest struct {
+	ExportTime int64           `json:"export_time"`
+	Counts     MediaCounts     `json:"counts"` // counts reported by GetMediaCounts when exporting
+	Media      []ExportedMedia `json:"media"`
+	News       []ExportedNews  `json:"news"`
+}
+
+func LoadMaterialManifest(dir string) (*MaterialManifest, error) {
+	manifest := &MaterialManifest{}
+	err := loadJSONFile(filepath.Join(dir, MaterialManifestFile), manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ExportMaterials downloads all the permanent images, voices, videos and news into dir,
+// and writes the manifest into MaterialManifestFile in dir.
+// The thumbs of the news which are not images are exported too.
+// Calling it again after failure skips the files already downloaded.
+func (c *Client) ExportMaterials(dir string) (*MaterialManifest, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	counts, err := c.GetMediaCounts()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &MaterialManifest{
+		ExportTime: time.Now().Unix(),
+		Counts:     *counts,
+	}
+	exported := make(map[string]bool)
+
+	for _, mediaType := range []string{MediaImage, MediaVoice, MediaVideo} {
+		it := c.IterateMedia(mediaType, 0)
+		for it.Next() {
+			media, err := c.exportMedia(dir, mediaType, it.Value())
+			if err != nil {
+				return nil, err
+			}
+			manifest.Media = append(manifest.Media, *media)
+			exported[media.Id] = true
+		}
+		if err = it.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	it := c.IterateNews(0)
+	for it.Next() {
+		item := it.Value()
+		for _, article := range item.Content.Articles {
+			if article.ThumbId == "" || exported[article.ThumbId] {
+				continue
+			}
+			media, err := c.exportMedia(dir, MediaThumb, &Media{Id: article.ThumbId, URL: article.ThumbURL})
+			if err != nil {
+				return nil, err
+			}
+			manifest.Media = append(manifest.Media, *media)
+			exported[media.Id] = true
+		}
+
+		manifest.News = append(manifest.News, ExportedNews{
+			Id:         item.Id,
+			UpdateTime: item.UpdateTime,
+			Articles:   item.Content.Articles,
+		})
+	}
+	if err = it.Err(); err != nil {
+		return nil, err
+	}
+
+	err = saveJSONFile(filepath.Join(dir, MaterialManifestFile), manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// exportMedia downloads the media into dir. The file already downloaded by the previous export is skipped,
+// so that calling ExportMaterials again after failure resumes the export.
+func (c *Client) exportMedia(dir, mediaType string, media *Media) (*ExportedMedia, error) {
+	exported := &ExportedMedia{
+		Type:       mediaType,
+		Id:         media.Id,
+		Name:       media.Name,
+		URL:        media.URL,
+		UpdateTime: media.UpdateTime,
+	}
+
+	typeDir := filepath.Join(dir, mediaType)
+	err := os.MkdirAll(typeDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	var video *Video
+	if mediaType == MediaVideo {
+		video, err = c.GetVideo(media.Id)
+		if err != nil {
+			return nil, err
+		}
+		exported.Title, exported.Description = video.Title, video.Description
+	}
+
+	fileName, err := existingMediaFile(typeDir, media.Id, filepath.Ext(media.Name))
+	if err != nil {
+		return nil, err
+	}
+	if fileName == "" {
+		// downloaded into a temporary file first, so that an interrupted download is not taken as exported
+		tmpPath := filepath.Join(typeDir, media.Id+".download")
+		if video != nil {
+			var rep Err
+			err = c.DownloadFile(URL(video.URL), nil, tmpPath, &rep)
+		} else {
+			err = c.DownloadMedia(media.Id, tmpPath)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ext := filepath.Ext(media.Name)
+		if ext == "" {
+			if ext, err = detectMediaExt(tmpPath, mediaType); err != nil {
+				return nil, err
+			}
+		}
+		fileName = media.Id + ext
+		if err = os.Rename(tmpPath, filepath.Join(typeDir, fileName)); err != nil {
+			return nil, err
+		}
+	}
+
+	exported.File = path.Join(mediaType, fileName)
+	return exported, nil
+}
+
+// existingMediaFile returns the name of the file of the media in dir, or "" if not found.
+// If the extension is unknown, the file of any extension is taken.
+func existingMediaFile(dir, id, ext string) (string, error) {
+	if ext != "" {
+		_, err := os.Stat(filepath.Join(dir, id+ext))
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return id + ext, nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, id+".*"))
+	if err != nil {
+		return "", err
+	}
+	for _, match := range matches {
+		if filepath.Ext(match) != ".download" {
+			return filepath.Base(match), nil
+		}
+	}
+	return "", nil
+}
+
+var mediaTypeExts = map[string]string{
+	MediaImage: ".jpg",
+	MediaThumb: ".jpg",
+	MediaVoice: ".mp3",
+	MediaVideo: ".mp4",
+}
+
+var contentTypeExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/bmp":  ".bmp",
+	"audio/mpeg": ".mp3",
+	"audio/wave": ".wav",
+	"video/mp4":  ".mp4",
+}
+
+// detectMediaExt returns the extension of the downloaded media file by its content,
+// or by the media type if the content is not recognized, as the uploads require the extension.
+func detectMediaExt(filePath, mediaType string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+
+	if bytes.HasPrefix(head, []byte("#!AMR")) {
+		return ".amr", nil
+	}
+	if ext, ok := contentTypeExts[http.DetectContentType(head)]; ok {
+		return ext, nil
+	}
+	return mediaTypeExts[mediaType], nil
+}
+
+// MaterialImport maps the exported materials to the imported ones.
+type MaterialImport struct {
+	MediaIds map[string]string `json:"media_ids"` // exported media id to imported media id
+	URLs     map[string]string `json:"urls"`      // exported image URL to imported image URL
+	NewsIds  map[string]string `json:"news_ids"`  // exported news media id to imported news media id
+}
+
+// ImportMaterials uploads the materials exported into dir by ExportMaterials, usually of another account.
+// The thumb media ids in the news are replaced by the imported ones, and so are the URLs of
+// the exported images in the content. Images uploaded by UploadArticleImage are not materials,
+// so their URLs are kept as is.
+//
+// The progress is saved into MaterialImportFile in dir after each upload,
+// so calling it again after failure continues from where it stopped.
+func (c *Client) ImportMaterials(dir string) (*MaterialImport, error) {
+	manifest, err := LoadMaterialManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	importFile := filepath.Join(dir, MaterialImportFile)
+	imported := &MaterialImport{}
+	err = loadJSONFile(importFile, imported)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if imported.MediaIds == nil {
+		imported.MediaIds = make(map[string]string)
+	}
+	if imported.URLs == nil {
+		imported.URLs = make(map[string]string)
+	}
+	if imported.NewsIds == nil {
+		imported.NewsIds = make(map[string]string)
+	}
+
+	for i := range manifest.Media {
+		exported := &manifest.Media[i]
+		if _, ok := imported.MediaIds[exported.Id]; ok {
+			continue
+		}
+
+		filePath := filepath.Join(dir, filepath.FromSlash(exported.File))
+		var media *Media
+		if exported.Type == MediaVideo {
+			media, err = c.UploadVideo(exported.Title, exported.Description, filePath)
+		} else {
+			media, err = c.UploadMedia(exported.Type, filePath)
+		}
+		if err != nil {
+			return imported, fmt.Errorf("import %s %s failed: %s", exported.Type, exported.Id, err)
+		}
+
+		imported.MediaIds[exported.Id] = media.Id
+		if exported.URL != "" && media.URL != "" {
+			imported.URLs[exported.URL] = media.URL
+		}
+		if err = saveJSONFile(importFile, imported); err != nil {
+			return imported, err
+		}
+	}
+
+	replacer := imported.urlReplacer()
+	for i := range manifest.News {
+		exported := &manifest.News[i]
+		if _, ok := imported.NewsIds[exported.Id]; ok {
+			continue
+		}
+
+		articles := make([]Article, len(exported.Articles))
+		for j, article := range exported.Articles {
+			thumbId, ok := imported.MediaIds[article.ThumbId]
+			if !ok {
+				return imported, fmt.Errorf("import news %s failed: thumb %s not imported", exported.Id, article.ThumbId)
+			}
+			article.ThumbId = thumbId
+			article.Content = replacer.Replace(article.Content)
+			article.ThumbURL, article.URL = "", ""
+			articles[j] = article
+		}
+
+		mediaId, err := c.CreateNews(&News{Articles: articles})
+		if err != nil {
+			return imported, fmt.Errorf("import news %s failed: %s", exported.Id, err)
+		}
+
+		imported.NewsIds[exported.Id] = mediaId
+		if err = saveJSONFile(importFile, imported); err != nil {
+			return imported, err
+		}
+	}
+
+	return imported, nil
+}
+
+// urlReplacer replaces the image URLs regardless of http or https.
+func (imp *MaterialImport) urlReplacer() *strings.Replacer {
+	oldnew := make([]string, 0, 2*len(imp.URLs))
+	for old, new := range imp.URLs {
+		oldnew = append(oldnew, trimURLScheme(old), trimURLScheme(new))
+	}
+	return strings.NewReplacer(oldnew...)
+}
+
+func trimURLScheme(u string) string {
+	if i := strings.Index(u, "://"); i >= 0 {
+		return u[i+3:]
+	}
+	return u
+}
+
+func loadJSONFile(filePath string, v interface{}) error {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSONFile writes into a temp file and renames it, so a crash does not leave a broken file.
+func saveJSONFile(filePath string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filePath + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
@@ -0,0 +1,351 @@
+package mp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TempMediaValidity is the validity duration of temporary media.
+const TempMediaValidity = 3 * 24 * time.Hour
+
+type CachedMedia struct {
+	Key       string    `json:"key"`
+	Type      string    `json:"type"`
+	Permanent bool      `json:"permanent"`
+	MediaID   string    `json:"media_id"`
+	URL       string    `json:"url,omitempty"` // only for permanent image and thumb
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MediaCacheStore persists the media IDs of the uploaded contents.
+type MediaCacheStore interface {
+	GetMedia(key string) (*CachedMedia, error) // returns nil media if not found
+	PutMedia(media *CachedMedia) error
+	DeleteMedia(key string) error
+	RangeMedia(f func(media *CachedMedia) bool) error // iterates until f returns false
+}
+
+type MemoryMediaCacheStore struct {
+	mutex sync.RWMutex
+	media map[string]CachedMedia
+}
+
+func NewMemoryMediaCacheStore() *MemoryMediaCacheStore {
+	return &MemoryMediaCacheStore{
+		media: make(map[string]CachedMedia),
+	}
+}
+
+func (s *MemoryMediaCacheStore) GetMedia(key string) (*CachedMedia, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	media, ok := s.media[key]
+	if !ok {
+		return nil, nil
+	}
+	return &media, nil
+}
+
+func (s *MemoryMediaCacheStore) PutMedia(media *CachedMedia) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.media[media.Key] = *media
+	return nil
+}
+
+func (s *MemoryMediaCacheStore) DeleteMedia(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.media, key)
+	return nil
+}
+
+func (s *MemoryMediaCacheStore) RangeMedia(f func(media *CachedMedia) bool) error {
+	s.mutex.RLock()
+	all := make([]CachedMedia, 0, len(s.media))
+	for _, media := range s.media {
+		all = append(all, media)
+	}
+	s.mutex.RUnlock()
+
+	for i := range all {
+		if !f(&all[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+// MediaCache uploads media only if the same content of the same media type has not been uploaded,
+// or the uploaded one is expired or deleted.
+type MediaCache struct {
+	client *Client
+	store  MediaCacheStore
+
+	// TempMediaTTL is how long the cached temporary media is used, default 3 days minus 1 hour,
+	// leaving time for the media ID to be used.
+	TempMediaTTL time.Duration
+}
+
+func NewMediaCache(client *Client, store ...MediaCacheStore) *MediaCache {
+	mc := &MediaCache{
+		client:       client,
+		TempMediaTTL: TempMediaValidity - time.Hour,
+	}
+	if len(store) > 0 {
+		mc.store = store[0]
+	} else {
+		mc.store = NewMemoryMediaCacheStore()
+	}
+	return mc
+}
+
+// mediaCacheKey returns the cache key of the media. The extra fields, e.g. the title of a video,
+// are part of the key, as the same content uploaded with other fields is another media.
+func mediaCacheKey(mediaType string, permanent bool, sum []byte, extraFields []map[string]string) string {
+	var fields []string
+	for _, m := range extraFields {
+		for k, v := range m {
+			fields = append(fields, k+"="+v)
+		}
+	}
+	if len(fields) > 0 {
+		sort.Strings(fields)
+		h := sha256.New()
+		h.Write(sum)
+		for _, field := range fields {
+			h.Write([]byte{0})
+			h.Write([]byte(field))
+		}
+		sum = h.Sum(nil)
+	}
+
+	kind := "temp"
+	if permanent {
+		kind = "perm"
+	}
+	return kind + "/" + mediaType + "/" + hex.EncodeToString(sum)
+}
+
+// hashContent returns the SHA-256 of the content, and a reader of the content from the beginning.
+func hashContent(reader io.Reader, buf *fileBuf) (sum []byte, content io.Reader, err error) {
+	h := sha256.New()
+
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err = io.Copy(h, seeker); err != nil {
+			return nil, nil, err
+		}
+		if _, err = seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		return h.Sum(nil), seeker, nil
+	}
+
+	if _, err = io.Copy(io.MultiWriter(buf, h), reader); err != nil {
+		return
+	}
+	content, err = buf.ReadSeeker()
+	if err != nil {
+		return
+	}
+	return h.Sum(nil), content, nil
+}
+
+func (mc *MediaCache) upload(mediaType string, permanent bool, reader io.Reader, fileName, contentType string, extraFields []map[string]string) (*CachedMedia, error) {
+	var buf fileBuf
+	defer buf.Close()
+
+	sum, content, err := hashContent(reader, &buf)
+	if err != nil {
+		return nil, err
+	}
+
+	key := mediaCacheKey(mediaType, permanent, sum, extraFields)
+	media, err := mc.store.GetMedia(key)
+	if err != nil {
+		return nil, err
+	}
+	if media != nil && (permanent || time.Since(media.CreatedAt) < mc.TempMediaTTL) {
+		return media, nil
+	}
+
+	media = &CachedMedia{
+		Key:       key,
+		Type:      mediaType,
+		Permanent: permanent,
+	}
+	if permanent {
+		m, err := mc.client.UploadMediaFromReader(mediaType, content, fileName, contentType, extraFields...)
+		if err != nil {
+			return nil, err
+		}
+		media.MediaID, media.URL, media.CreatedAt = m.Id, m.URL, time.Now()
+	} else {
+		m, err := mc.uploadTemp(mediaType, content, fileName, contentType)
+		if err != nil {
+			return nil, err
+		}
+		media.MediaID, media.CreatedAt = m.Id, time.Unix(m.CreatedAt, 0)
+	}
+
+	if media.MediaID == "" {
+		return nil, fmt.Errorf("no media id in the response of uploading %s media", mediaType)
+	}
+	if err = mc.store.PutMedia(media); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// uploadTemp uploads the temporary media, whose id of a thumb is in thumb_media_id instead of media_id.
+func (mc *MediaCache) uploadTemp(mediaType string, reader io.Reader, fileName, contentType string) (*TempMedia, error) {
+	if mediaType != MediaThumb {
+		return mc.client.UploadTempMediaFromReader(mediaType, reader, fileName, contentType)
+	}
+
+	u := BASE_URL.Join("/media/upload").Query("type", mediaType)
+
+	var rep struct {
+		Err
+		TempMedia
+		ThumbId string `json:"thumb_media_id"`
+	}
+
+	err := mc.client.UploadFromReader(u, "media", reader, fileName, contentType, nil, &rep)
+	if err != nil {
+		return nil, err
+	}
+	if rep.Id == "" {
+		rep.Id = rep.ThumbId
+	}
+	return &rep.TempMedia, nil
+}
+
+func (mc *MediaCache) UploadTempMedia(mediaType, filePath string) (*CachedMedia, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return mc.UploadTempMediaFromReader(mediaType, file, filepath.Base(filePath), "")
+}
+
+func (mc *MediaCache) UploadTempMediaFromReader(mediaType string, reader io.Reader, fileName, contentType string) (*CachedMedia, error) {
+	return mc.upload(mediaType, false, reader, fileName, contentType, nil)
+}
+
+func (mc *MediaCache) UploadMedia(mediaType, filePath string, extraFields ...map[string]string) (*CachedMedia, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return mc.UploadMediaFromReader(mediaType, file, filepath.Base(filePath), "", extraFields...)
+}
+
+func (mc *MediaCache) UploadMediaFromReader(mediaType string, reader io.Reader, fileName, contentType string, extraFields ...map[string]string) (*CachedMedia, error) {
+	return mc.upload(mediaType, true, reader, fileName, contentType, extraFields)
+}
+
+// Invalidate removes the media from the cache, e.g. when using it fails with InvalidMediaID,
+// so the next upload of the same content will really upload it.
+func (mc *MediaCache) Invalidate(mediaID string) error {
+	var keys []string
+	err := mc.store.RangeMedia(func(media *CachedMedia) bool {
+		if media.MediaID == mediaID {
+			keys = append(keys, media.Key)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err = mc.store.DeleteMedia(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteMedia deletes the permanent media, and removes it from the cache.
+func (mc *MediaCache) DeleteMedia(mediaID string) error {
+	err := mc.client.DeleteMedia(mediaID)
+	if err != nil {
+		if e, ok := err.(Error); !ok || e.Code() != InvalidMediaID {
+			return err
+		}
+	}
+	return mc.Invalidate(mediaID)
+}
+
+// Sync removes the permanent images, voices, thumbs and videos which have been deleted outside,
+// e.g. on the official website, and the expired temporary media from the cache.
+// The permanent thumbs are listed as images by batchget_material.
+func (mc *MediaCache) Sync() error {
+	existing := make(map[string]bool)
+	for _, mediaType := range []string{MediaImage, MediaVoice} {
+		it := mc.client.IterateMedia(mediaType, 0)
+		for it.Next() {
+			existing[it.Value().Id] = true
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+
+	var stale []string
+	var rangeErr error
+	err := mc.store.RangeMedia(func(media *CachedMedia) bool {
+		switch {
+		case !media.Permanent:
+			if time.Since(media.CreatedAt) >= mc.TempMediaTTL {
+				stale = append(stale, media.Key)
+			}
+		case media.Type == MediaVideo:
+			if _, err := mc.client.GetVideo(media.MediaID); err != nil {
+				if e, ok := err.(Error); ok && e.Code() == InvalidMediaID {
+					stale = append(stale, media.Key)
+				} else {
+					rangeErr = err
+					return false
+				}
+			}
+		case media.Type == MediaImage || media.Type == MediaVoice || media.Type == MediaThumb:
+			if !existing[media.MediaID] {
+				stale = append(stale, media.Key)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if rangeErr != nil {
+		return rangeErr
+	}
+
+	for _, key := range stale {
+		if err = mc.store.DeleteMedia(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package mp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMediaCacheUpload(t *testing.T) {
+	var uploads int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&uploads, 1)
+		created := time.Now().Unix()
+		switch r.URL.Query().Get("type") {
+		case MediaThumb:
+			w.Write([]byte(`{"type":"thumb","thumb_media_id":"thumb1","created_at":` + strconv.FormatInt(created, 10) + `}`))
+		case MediaVoice:
+			w.Write([]byte(`{"type":"voice","created_at":` + strconv.FormatInt(created, 10) + `}`)) // no media id
+		default:
+			w.Write([]byte(`{"type":"image","media_id":"image1","created_at":` + strconv.FormatInt(created, 10) + `}`))
+		}
+	})
+	mc := NewMediaCache(c)
+
+	tests := []struct {
+		name      string
+		mediaType string
+		content   string
+		wantID    string // empty if failed
+		wantCalls int32  // uploads after uploading twice
+	}{
+		{name: "image", mediaType: MediaImage, content: "image", wantID: "image1", wantCalls: 1},
+		{name: "thumb", mediaType: MediaThumb, content: "thumb", wantID: "thumb1", wantCalls: 1},
+		{name: "no media id not cached", mediaType: MediaVoice, content: "voice", wantCalls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&uploads, 0)
+			for i := 0; i < 2; i++ {
+				media, err := mc.UploadTempMediaFromReader(tt.mediaType, strings.NewReader(tt.content), "file", "")
+				if tt.wantID == "" {
+					if err == nil {
+						t.Fatalf("got %+v, want error", media)
+					}
+					continue
+				}
+				if err != nil || media.MediaID != tt.wantID {
+					t.Fatalf("got %+v, %v, want media id %s", media, err, tt.wantID)
+				}
+			}
+			if n := atomic.LoadInt32(&uploads); n != tt.wantCalls {
+				t.Errorf("uploaded %d times, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestMediaCacheSync(t *testing.T) {
+	var listed []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cgi-bin/material/batchget_material":
+			var req struct {
+				Type string `json:"type"`
+			}
+			data, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(data, &req)
+			listed = append(listed, req.Type)
+			switch req.Type {
+			case MediaImage:
+				// the permanent thumbs are listed as images
+				w.Write([]byte(`{"total_count":2,"item_count":2,"item":[{"media_id":"image1"},{"media_id":"thumb1"}]}`))
+			case MediaVoice:
+				w.Write([]byte(`{"total_count":0,"item_count":0,"item":[]}`))
+			default:
+				w.Write([]byte(`{"errcode":40004,"errmsg":"invalid media type"}`))
+			}
+		default:
+			w.Write([]byte(`{"errcode":40035,"errmsg":"invalid parameter"}`))
+		}
+	})
+
+	store := NewMemoryMediaCacheStore()
+	now := time.Now()
+	for _, media := range []*CachedMedia{
+		{Key: "image1", Type: MediaImage, Permanent: true, MediaID: "image1", CreatedAt: now},
+		{Key: "thumb1", Type: MediaThumb, Permanent: true, MediaID: "thumb1", CreatedAt: now},
+		{Key: "thumb2", Type: MediaThumb, Permanent: true, MediaID: "thumb2", CreatedAt: now},
+		{Key: "voice1", Type: MediaVoice, Permanent: true, MediaID: "voice1", CreatedAt: now},
+		{Key: "temp1", Type: MediaImage, MediaID: "temp1", CreatedAt: now.Add(-TempMediaValidity)},
+	} {
+		store.PutMedia(media)
+	}
+
+	if err := NewMediaCache(c, store).Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(listed, ",") != "image,voice" {
+		t.Errorf("listed %v, want image and voice", listed)
+	}
+
+	var kept []string
+	store.RangeMedia(func(media *CachedMedia) bool {
+		kept = append(kept, media.Key)
+		return true
+	})
+	sort.Strings(kept)
+	if strings.Join(kept, ",") != "image1,thumb1" {
+		t.Errorf("kept %v, want image1 and thumb1", kept)
+	}
+}
@@ -1,5 +1,10 @@
 package mp
 
+import (
+	"strconv"
+	"strings"
+)
+
 const (
 	// 下面6个类型(包括view类型)的按钮是在公众平台官网发布的菜单按钮类型
 	ButtonTypeText  = "text"
@@ -35,8 +40,18 @@ type Menu struct {
 	MenuId    int64      `json:"menuid,omitempty"`
 }
 
+const (
+	MatchSexMale   = 1
+	MatchSexFemale = 2
+
+	MatchPlatformIOS     = 1
+	MatchPlatformAndroid = 2
+	MatchPlatformOthers  = 3
+)
+
 type MatchRule struct {
-	GroupID            *int64 `json:"group_id,omitempty"`
+	TagID              *int64 `json:"tag_id,omitempty"`
+	GroupID            *int64 `json:"group_id,omitempty"` // deprecated, use TagID
 	Sex                *int   `json:"sex,omitempty"`
 	Country            string `json:"country,omitempty"`
 	Province           string `json:"province,omitempty"`
@@ -160,19 +175,37 @@ func (c *Client) CreateMenu(menu *Menu) error {
 	return c.Post(u, menu, &rep)
 }
 
+// flexibleID is an ID which may be a JSON number or a JSON string of the number,
+// e.g. the menuid of menu/addconditional is a string, while it is a number in menu/get.
+type flexibleID int64
+
+func (id *flexibleID) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*id = 0
+		return nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*id = flexibleID(n)
+	return nil
+}
+
 func (c *Client) CreateConditionalMenu(menu *Menu) (menuID int64, err error) {
 	u := BASE_URL.Join("/menu/addconditional")
 
 	var rep struct {
 		Err
-		MenuID int64 `json:"menuId"`
+		MenuID flexibleID `json:"menuid"`
 	}
 
 	err = c.Post(u, menu, &rep)
 	if err != nil {
 		return
 	}
-	menuID = rep.MenuID
+	menuID = int64(rep.MenuID)
 	return
 }
 
@@ -200,11 +233,13 @@ func (c *Client) DeleteMenu() error {
 	return c.Get(u, &rep)
 }
 
-func (c *Client) DeleteConditionalMenu(menuID *Menu) error {
+func (c *Client) DeleteConditionalMenu(menuID int64) error {
 	u := BASE_URL.Join("/menu/delconditional")
 
-	var req struct{
-		MenuID int64 `json:"menuId"`
+	var req = struct {
+		MenuID int64 `json:"menuid,string"`
+	}{
+		MenuID: menuID,
 	}
 
 	var rep Err
@@ -213,9 +248,107 @@ func (c *Client) DeleteConditionalMenu(menuID *Menu) error {
 	return err
 }
 
+// TryMatchMenu returns the menu which the user will see. userID can be OpenID or WeChat ID.
+func (c *Client) TryMatchMenu(userID string) (*Menu, error) {
+	u := BASE_URL.Join("/menu/trymatch")
+
+	var req = struct {
+		UserID string `json:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	var rep struct {
+		Err
+		Buttons []*Button `json:"button"`
+	}
+
+	err := c.Post(u, &req, &rep)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Menu{Buttons: rep.Buttons}, nil
+}
+
+// SelfMenuButton is the button returned by GetSelfMenuInfo,
+// which may be configured through API or on the official website.
+type SelfMenuButton struct {
+	Type       string `json:"type,omitempty"`
+	Name       string `json:"name"`
+	Key        string `json:"key,omitempty"`
+	URL        string `json:"url,omitempty"`
+	Value      string `json:"value,omitempty"` // text content, media id or URL, for buttons configured on the website
+	AppID      string `json:"appid,omitempty"`
+	PagePath   string `json:"pagepath,omitempty"`
+	SubButtons struct {
+		List []*SelfMenuButton `json:"list"`
+	} `json:"sub_button"`
+	NewsInfo struct {
+		List []SelfMenuNews `json:"list"`
+	} `json:"news_info"` // only for button type "news"
+}
+
+type SelfMenuNews struct {
+	Title      string `json:"title"`
+	Author     string `json:"author"`
+	Digest     string `json:"digest"`
+	ShowCover  int    `json:"show_cover"`
+	CoverURL   string `json:"cover_url"`
+	ContentURL string `json:"content_url"`
+	SourceURL  string `json:"source_url"`
+}
+
+type SelfMenuInfo struct {
+	IsMenuOpen int `json:"is_menu_open"`
+	Info       struct {
+		Buttons []*SelfMenuButton `json:"button"`
+	} `json:"selfmenu_info"`
+}
+
+// GetSelfMenuInfo returns the current menu, no matter it is configured through API or on the official website.
+func (c *Client) GetSelfMenuInfo() (*SelfMenuInfo, error) {
+	u := BASE_URL.Join("/get_current_selfmenu_info")
+
+	var rep struct {
+		Err
+		SelfMenuInfo
+	}
+
+	err := c.Get(u, &rep)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rep.SelfMenuInfo, nil
+}
+
 func (c *Client) CreateCorpMenu(menu *Menu) error {
 	u := CORP_BASE_URL.Join("/menu/create")
 
 	var rep Err
 	return c.Post(c.urlAddAgentID(u), menu, &rep)
 }
+
+func (c *Client) GetCorpMenu() (*Menu, error) {
+	u := CORP_BASE_URL.Join("/menu/get")
+
+	var rep struct {
+		Err
+		Buttons []*Button `json:"button"`
+	}
+
+	err := c.Get(c.urlAddAgentID(u), &rep)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Menu{Buttons: rep.Buttons}, nil
+}
+
+func (c *Client) DeleteCorpMenu() error {
+	u := CORP_BASE_URL.Join("/menu/delete")
+
+	var rep Err
+	return c.Get(c.urlAddAgentID(u), &rep)
+}
@@ -0,0 +1,375 @@
+package mp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// Limits of custom menus imposed by WeChat.
+const (
+	MaxMenuButtons      = 3
+	MaxMenuSubButtons   = 5
+	MaxMenuNameBytes    = 16
+	MaxMenuSubNameBytes = 60
+	MaxMenuKeyBytes     = 128
+	MaxMenuURLBytes     = 1024
+	MaxConditionalMenus = 20
+)
+
+// MenuSpec is the declarative description of all the menus of an account.
+// It has the same layout as the result of GetMenus, so the output of menu/get
+// can be used as a spec directly.
+type MenuSpec struct {
+	Menu             *Menu  `json:"menu,omitempty"`
+	ConditionalMenus []Menu `json:"conditionalmenu,omitempty"`
+}
+
+// LoadMenuSpec reads a menu spec from a JSON or YAML file.
+func LoadMenuSpec(filePath string) (*MenuSpec, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMenuSpec(data)
+}
+
+// ParseMenuSpec parses a menu spec in JSON or YAML, and validates it.
+func ParseMenuSpec(data []byte) (*MenuSpec, error) {
+	data, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec MenuSpec
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&spec); err != nil {
+		return nil, err
+	}
+
+	if err = spec.Validate(); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// MenuError describes a menu which violates the rules of WeChat.
+type MenuError struct {
+	Path string // e.g. "conditionalmenu[0].button[1].sub_button[2].name"
+	Msg  string
+}
+
+func (err *MenuError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Path, err.Msg)
+}
+
+// MenuErrors collects all the violations found in a menu spec.
+type MenuErrors []*MenuError
+
+func (errs MenuErrors) Error() string {
+	strs := make([]string, len(errs))
+	for i, err := range errs {
+		strs[i] = err.Error()
+	}
+	return strings.Join(strs, "; ")
+}
+
+func (spec *MenuSpec) Validate() error {
+	var errs MenuErrors
+
+	if spec.Menu == nil || len(spec.Menu.Buttons) == 0 {
+		if len(spec.ConditionalMenus) > 0 {
+			errs = append(errs, &MenuError{"menu", "conditional menus require a default menu"})
+		}
+	} else {
+		if spec.Menu.MatchRule != nil {
+			errs = append(errs, &MenuError{"menu.matchrule", "default menu must not have a match rule"})
+		}
+		errs = validateMenu("menu", spec.Menu, errs)
+	}
+
+	if len(spec.ConditionalMenus) > MaxConditionalMenus {
+		errs = append(errs, &MenuError{"conditionalmenu", fmt.Sprintf("more than %d conditional menus", MaxConditionalMenus)})
+	}
+
+	rules := make(map[string]int)
+	for i := range spec.ConditionalMenus {
+		menu := &spec.ConditionalMenus[i]
+		path := fmt.Sprintf("conditionalmenu[%d]", i)
+		if menu.MatchRule == nil || menu.MatchRule.isEmpty() {
+			errs = append(errs, &MenuError{path + ".matchrule", "conditional menu requires a match rule"})
+		} else {
+			key := menu.MatchRule.key()
+			if j, ok := rules[key]; ok {
+				errs = append(errs, &MenuError{path + ".matchrule", fmt.Sprintf("duplicate of conditionalmenu[%d]", j)})
+			} else {
+				rules[key] = i
+			}
+		}
+		errs = validateMenu(path, menu, errs)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ValidateMenu checks the menu against the rules of WeChat.
+func ValidateMenu(menu *Menu) error {
+	errs := validateMenu("menu", menu, nil)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateMenu(path string, menu *Menu, errs MenuErrors) MenuErrors {
+	switch n := len(menu.Buttons); {
+	case n == 0:
+		errs = append(errs, &MenuError{path + ".button", "no buttons"})
+	case n > MaxMenuButtons:
+		errs = append(errs, &MenuError{path + ".button", fmt.Sprintf("%d buttons exceed the limit %d", n, MaxMenuButtons)})
+	}
+
+	for i, button := range menu.Buttons {
+		p := fmt.Sprintf("%s.button[%d]", path, i)
+		if button == nil {
+			errs = append(errs, &MenuError{p, "empty button"})
+			continue
+		}
+		errs = validateButton(p, button, MaxMenuNameBytes, errs)
+
+		if len(button.SubButtons) == 0 {
+			continue
+		}
+		if button.Type != "" {
+			errs = append(errs, &MenuError{p + ".type", "button with sub buttons must not have a type"})
+		}
+		if n := len(button.SubButtons); n > MaxMenuSubButtons {
+			errs = append(errs, &MenuError{p + ".sub_button", fmt.Sprintf("%d sub buttons exceed the limit %d", n, MaxMenuSubButtons)})
+		}
+		for j, subButton := range button.SubButtons {
+			sp := fmt.Sprintf("%s.sub_button[%d]", p, j)
+			if subButton == nil {
+				errs = append(errs, &MenuError{sp, "empty button"})
+				continue
+			}
+			if len(subButton.SubButtons) > 0 {
+				errs = append(errs, &MenuError{sp + ".sub_button", "sub buttons can not be nested"})
+			}
+			errs = validateButton(sp, subButton, MaxMenuSubNameBytes, errs)
+		}
+	}
+	return errs
+}
+
+func validateButton(path string, button *Button, maxNameBytes int, errs MenuErrors) MenuErrors {
+	lengthErr := func(field string, n, max int) {
+		errs = append(errs, &MenuError{path + "." + field, fmt.Sprintf("%d bytes exceed the limit %d", n, max)})
+	}
+	requireErr := func(field string) {
+		errs = append(errs, &MenuError{path + "." + field, fmt.Sprintf("required by button type '%s'", button.Type)})
+	}
+
+	if button.Name == "" {
+		errs = append(errs, &MenuError{path + ".name", "empty name"})
+	} else if n := len(button.Name); n > maxNameBytes {
+		lengthErr("name", n, maxNameBytes)
+	}
+	if n := len(button.Key); n > MaxMenuKeyBytes {
+		lengthErr("key", n, MaxMenuKeyBytes)
+	}
+	if n := len(button.URL); n > MaxMenuURLBytes {
+		lengthErr("url", n, MaxMenuURLBytes)
+	}
+
+	if len(button.SubButtons) > 0 {
+		return errs
+	}
+
+	switch button.Type {
+	case ButtonTypeClick, ButtonTypeScanCodePush, ButtonTypeScanCodeWaitMsg, ButtonTypePicSysPhoto,
+		ButtonTypePicPhotoOrAlbum, ButtonTypePicWeixin, ButtonTypeLocationSelect:
+		if button.Key == "" {
+			requireErr("key")
+		}
+	case ButtonTypeView:
+		if button.URL == "" {
+			requireErr("url")
+		}
+	case ButtonTypeMiniprogram:
+		if button.URL == "" {
+			requireErr("url")
+		}
+		if button.AppID == "" {
+			requireErr("appid")
+		}
+		if button.PagePath == "" {
+			requireErr("pagepath")
+		}
+	case ButtonTypeMediaId, ButtonTypeViewLimited:
+		if button.MediaID == "" {
+			requireErr("media_id")
+		}
+	case "":
+		errs = append(errs, &MenuError{path + ".type", "empty type"})
+	default:
+		errs = append(errs, &MenuError{path + ".type", fmt.Sprintf("button type '%s' can not be set by API", button.Type)})
+	}
+	return errs
+}
+
+func (rule *MatchRule) isEmpty() bool {
+	return rule.key() == "{}"
+}
+
+// key returns the canonical form of the match rule, used to identify a conditional menu.
+func (rule *MatchRule) key() string {
+	if rule == nil {
+		return ""
+	}
+	data, _ := json.Marshal(rule)
+	return string(data)
+}
+
+func buttonsEqual(a, b []*Button) bool {
+	return bytes.Equal(mustMarshal(a), mustMarshal(b))
+}
+
+// MenuDiff is the set of changes which turns the current menus into the spec.
+type MenuDiff struct {
+	DeleteAll     bool   // delete the default menu, which also deletes all conditional menus
+	UpdateDefault bool   // (re)create the default menu
+	Delete        []Menu // conditional menus to delete
+	Create        []Menu // conditional menus to create
+}
+
+func (diff *MenuDiff) IsEmpty() bool {
+	return !diff.DeleteAll && !diff.UpdateDefault && len(diff.Delete) == 0 && len(diff.Create) == 0
+}
+
+func (diff *MenuDiff) String() string {
+	if diff.IsEmpty() {
+		return "menus are up to date"
+	}
+
+	var strs []string
+	if diff.DeleteAll {
+		strs = append(strs, "delete all menus")
+	}
+	if diff.UpdateDefault {
+		strs = append(strs, "update default menu")
+	}
+	for _, menu := range diff.Delete {
+		strs = append(strs, fmt.Sprintf("delete conditional menu %d %s", menu.MenuId, menu.MatchRule.key()))
+	}
+	for _, menu := range diff.Create {
+		strs = append(strs, fmt.Sprintf("create conditional menu %s", menu.MatchRule.key()))
+	}
+	return strings.Join(strs, "\n")
+}
+
+// DiffMenus compares the spec with the current menus returned by GetMenus.
+// Conditional menus are identified by their match rules; a changed conditional
+// menu is deleted and created again, since WeChat can not update it in place.
+func DiffMenus(spec *MenuSpec, current *Menu, currentConditionalMenus []Menu) *MenuDiff {
+	diff := &MenuDiff{}
+
+	hasCurrent := current != nil && len(current.Buttons) > 0
+	if spec.Menu == nil || len(spec.Menu.Buttons) == 0 {
+		diff.DeleteAll = hasCurrent
+		return diff
+	}
+
+	if !hasCurrent || !buttonsEqual(spec.Menu.Buttons, current.Buttons) {
+		diff.UpdateDefault = true
+	}
+
+	wanted := make(map[string]bool)
+	for _, menu := range spec.ConditionalMenus {
+		wanted[menu.MatchRule.key()+string(mustMarshal(menu.Buttons))] = true
+	}
+
+	existing := make(map[string]bool)
+	for _, menu := range currentConditionalMenus {
+		key := menu.MatchRule.key() + string(mustMarshal(menu.Buttons))
+		if wanted[key] && !existing[key] {
+			existing[key] = true
+		} else {
+			diff.Delete = append(diff.Delete, menu)
+		}
+	}
+
+	for _, menu := range spec.ConditionalMenus {
+		key := menu.MatchRule.key() + string(mustMarshal(menu.Buttons))
+		if !existing[key] {
+			menu.MenuId = 0
+			diff.Create = append(diff.Create, menu)
+		}
+	}
+
+	return diff
+}
+
+func mustMarshal(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// SyncMenus makes the menus of the account the same as the spec, only calling
+// the needed create/delete APIs. If dryRun is true, it only computes the diff.
+func (c *Client) SyncMenus(spec *MenuSpec, dryRun bool) (*MenuDiff, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	current, conditionalMenus, err := c.GetMenus()
+	if err != nil {
+		if e, ok := err.(Error); !ok || e.Code() != MenuNotExist {
+			return nil, err
+		}
+		current, conditionalMenus = nil, nil
+	}
+
+	diff := DiffMenus(spec, current, conditionalMenus)
+	if dryRun || diff.IsEmpty() {
+		return diff, nil
+	}
+
+	if diff.DeleteAll {
+		return diff, c.DeleteMenu()
+	}
+
+	if diff.UpdateDefault {
+		menu := *spec.Menu
+		menu.MenuId = 0
+		if err = c.CreateMenu(&menu); err != nil {
+			return diff, err
+		}
+	}
+
+	for i := range diff.Delete {
+		if err = c.DeleteConditionalMenu(diff.Delete[i].MenuId); err != nil {
+			return diff, err
+		}
+	}
+
+	for i := range diff.Create {
+		menuID, err := c.CreateConditionalMenu(&diff.Create[i])
+		if err != nil {
+			return diff, err
+		}
+		diff.Create[i].MenuId = menuID
+	}
+
+	return diff, nil
+}
@@ -0,0 +1,178 @@
+package mp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func testButtons(names ...string) []*Button {
+	buttons := make([]*Button, len(names))
+	for i, name := range names {
+		buttons[i] = &Button{Type: ButtonTypeClick, Name: name, Key: name}
+	}
+	return buttons
+}
+
+func testConditionalMenu(tagID int64, menuID int64, names ...string) Menu {
+	return Menu{
+		Buttons:   testButtons(names...),
+		MatchRule: &MatchRule{TagID: &tagID},
+		MenuId:    menuID,
+	}
+}
+
+func TestDiffMenus(t *testing.T) {
+	tests := []struct {
+		name          string
+		spec          *MenuSpec
+		current       *Menu
+		conditional   []Menu
+		deleteAll     bool
+		updateDefault bool
+		deleteIDs     []int64
+		createTags    []int64
+	}{
+		{
+			name:    "up to date",
+			spec:    &MenuSpec{Menu: &Menu{Buttons: testButtons("a")}, ConditionalMenus: []Menu{testConditionalMenu(1, 0, "b")}},
+			current: &Menu{Buttons: testButtons("a")},
+			conditional: []Menu{
+				testConditionalMenu(1, 100, "b"),
+			},
+		},
+		{
+			name:          "no current menu",
+			spec:          &MenuSpec{Menu: &Menu{Buttons: testButtons("a")}, ConditionalMenus: []Menu{testConditionalMenu(1, 0, "b")}},
+			updateDefault: true,
+			createTags:    []int64{1},
+		},
+		{
+			name:          "default menu changed",
+			spec:          &MenuSpec{Menu: &Menu{Buttons: testButtons("a", "b")}},
+			current:       &Menu{Buttons: testButtons("a")},
+			updateDefault: true,
+		},
+		{
+			name:        "conditional menu changed",
+			spec:        &MenuSpec{Menu: &Menu{Buttons: testButtons("a")}, ConditionalMenus: []Menu{testConditionalMenu(1, 0, "c")}},
+			current:     &Menu{Buttons: testButtons("a")},
+			conditional: []Menu{testConditionalMenu(1, 100, "b")},
+			deleteIDs:   []int64{100},
+			createTags:  []int64{1},
+		},
+		{
+			name:    "conditional menu removed and duplicated",
+			spec:    &MenuSpec{Menu: &Menu{Buttons: testButtons("a")}, ConditionalMenus: []Menu{testConditionalMenu(1, 0, "b")}},
+			current: &Menu{Buttons: testButtons("a")},
+			conditional: []Menu{
+				testConditionalMenu(1, 100, "b"),
+				testConditionalMenu(1, 101, "b"),
+				testConditionalMenu(2, 102, "b"),
+			},
+			deleteIDs: []int64{101, 102},
+		},
+		{
+			name:      "spec without menus",
+			spec:      &MenuSpec{},
+			current:   &Menu{Buttons: testButtons("a")},
+			deleteAll: true,
+		},
+		{
+			name: "nothing at all",
+			spec: &MenuSpec{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := DiffMenus(tt.spec, tt.current, tt.conditional)
+			if diff.DeleteAll != tt.deleteAll {
+				t.Errorf("DeleteAll = %v, want %v", diff.DeleteAll, tt.deleteAll)
+			}
+			if diff.UpdateDefault != tt.updateDefault {
+				t.Errorf("UpdateDefault = %v, want %v", diff.UpdateDefault, tt.updateDefault)
+			}
+
+			var deleteIDs []int64
+			for _, menu := range diff.Delete {
+				deleteIDs = append(deleteIDs, menu.MenuId)
+			}
+			if !int64sEqual(deleteIDs, tt.deleteIDs) {
+				t.Errorf("Delete = %v, want %v", deleteIDs, tt.deleteIDs)
+			}
+
+			var createTags []int64
+			for _, menu := range diff.Create {
+				if menu.MenuId != 0 {
+					t.Errorf("created menu has menuid %d", menu.MenuId)
+				}
+				createTags = append(createTags, *menu.MatchRule.TagID)
+			}
+			if !int64sEqual(createTags, tt.createTags) {
+				t.Errorf("Create = %v, want %v", createTags, tt.createTags)
+			}
+		})
+	}
+}
+
+func int64sEqual(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSyncMenus(t *testing.T) {
+	var calls []string
+	var deletedID string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path)
+		switch r.URL.Path {
+		case "/cgi-bin/menu/get":
+			w.Write([]byte(`{"menu":{"button":[{"type":"click","name":"a","key":"a"}],"menuid":1},` +
+				`"conditionalmenu":[{"button":[{"type":"click","name":"b","key":"b"}],"matchrule":{"tag_id":1},"menuid":208379533}]}`))
+		case "/cgi-bin/menu/delconditional":
+			var req map[string]interface{}
+			data, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(data, &req)
+			deletedID, _ = req["menuid"].(string)
+			w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+		case "/cgi-bin/menu/addconditional":
+			w.Write([]byte(`{"menuid":"208379534"}`))
+		default:
+			w.Write([]byte(`{"errcode":40035,"errmsg":"invalid parameter"}`))
+		}
+	})
+
+	spec := &MenuSpec{
+		Menu:             &Menu{Buttons: testButtons("a")},
+		ConditionalMenus: []Menu{testConditionalMenu(1, 0, "c")},
+	}
+	diff, err := c.SyncMenus(spec, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/cgi-bin/menu/get", "/cgi-bin/menu/delconditional", "/cgi-bin/menu/addconditional"}
+	if len(calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("calls = %v, want %v", calls, want)
+		}
+	}
+	if deletedID != "208379533" {
+		t.Errorf("deleted menuid = %q, want the string \"208379533\"", deletedID)
+	}
+	if id := diff.Create[0].MenuId; id != 208379534 {
+		t.Errorf("created menuid = %d, want 208379534", id)
+	}
+}
@@ -444,17 +444,17 @@ func (c *Client) CorpSendFileByTags(mediaID string, tagIDs []string) error {
 	return c.corpSendFile(mediaID, nil, nil, tagIDs)
 }
 
-type news struct {
+type MPNews struct {
 	MediaId string `json:"media_id"`
 }
 
 func (c *Client) sendNews(mediaId string, tagID []int, userIds []string) (id, dataId int, err error) {
 	var msg = struct {
 		*msgHeader
-		News news `json:"mpnews"`
+		News MPNews `json:"mpnews"`
 	}{
 		msgHeader: newMsgHeader(MsgMPNews, tagID, userIds),
-		News: news{
+		News: MPNews{
 			MediaId: mediaId,
 		},
 	}
@@ -466,10 +466,10 @@ func (c *Client) sendNews(mediaId string, tagID []int, userIds []string) (id, da
 func (c *Client) SendNewsForPreview(mediaId, wxName string) (id, dataId int, err error) {
 	var msg = struct {
 		*msgPreviewHeader
-		News news `json:"mpnews"`
+		News MPNews `json:"mpnews"`
 	}{
 		msgPreviewHeader: newMsgPreviewHeader(MsgMPNews, wxName),
-		News: news{
+		News: MPNews{
 			MediaId: mediaId,
 		},
 	}
@@ -486,6 +486,69 @@ func (c *Client) SendNewsByUsers(mediaId string, userIds []string) (id, dataId i
 	return c.sendNews(mediaId, nil, userIds)
 }
 
+// UploadNews uploads the articles as the news for mass messages, without occupying the permanent media quota.
+// The returned media id can be used by SendNewsAll, SendNewsByUsers and SendNewsForPreview.
+func (c *Client) UploadNews(articles []Article) (*TempMedia, error) {
+	u := BASE_URL.Join("/media/uploadnews")
+
+	var req = News{
+		Articles: articles,
+	}
+
+	var rep struct {
+		Err
+		TempMedia
+	}
+
+	err := c.Post(u, &req, &rep)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rep.TempMedia, nil
+}
+
+// Mass message speed levels
+const (
+	MassSpeed80W = iota // 800,000 per minute
+	MassSpeed60W
+	MassSpeed45W
+	MassSpeed30W
+	MassSpeed10W
+)
+
+// GetMassSpeed returns the speed level and the real speed(10,000 per minute) of mass messages.
+func (c *Client) GetMassSpeed() (speed, realSpeed int, err error) {
+	u := BASE_URL.Join("/message/mass/speed/get")
+
+	var rep struct {
+		Err
+		Speed     int `json:"speed"`
+		RealSpeed int `json:"realspeed"`
+	}
+
+	err = c.Post(u, struct{}{}, &rep)
+	if err != nil {
+		return
+	}
+
+	return rep.Speed, rep.RealSpeed, nil
+}
+
+func (c *Client) SetMassSpeed(speed int) error {
+	u := BASE_URL.Join("/message/mass/speed/set")
+
+	var req = struct {
+		Speed int `json:"speed"`
+	}{
+		Speed: speed,
+	}
+
+	var rep Err
+
+	return c.Post(u, &req, &rep)
+}
+
 type CorpArticle struct {
 	Title string `json:"title"`
 	Description string `json:"description"`
@@ -660,7 +723,14 @@ func (c *Client) DeleteMsg(msgId int64) error {
 	return c.Post(u, &req, &rep)
 }
 
-func (c *Client) IsMsgSent(msgId int64) (bool, error) {
+const (
+	MsgStatusSendSuccess = "SEND_SUCCESS"
+	MsgStatusSending     = "SENDING"
+	MsgStatusSendFail    = "SEND_FAIL"
+	MsgStatusDelete      = "DELETE"
+)
+
+func (c *Client) GetMsgStatus(msgId int64) (string, error) {
 	u := BASE_URL.Join("/message/mass/get")
 
 	var req = struct {
@@ -671,16 +741,25 @@ func (c *Client) IsMsgSent(msgId int64) (bool, error) {
 
 	var rep struct {
 		Err
-		Id     int    `json:"msg_id"`
+		Id     int64  `json:"msg_id"`
 		Status string `json:"msg_status"`
 	}
 
 	err := c.Post(u, &req, &rep)
+	if err != nil {
+		return "", err
+	}
+
+	return rep.Status, nil
+}
+
+func (c *Client) IsMsgSent(msgId int64) (bool, error) {
+	status, err := c.GetMsgStatus(msgId)
 	if err != nil {
 		return false, err
 	}
 
-	return rep.Status == "SEND_SUCCESS", nil
+	return status == MsgStatusSendSuccess, nil
 }
 
 func (ctx *Context) ReplyText(content string) {
@@ -0,0 +1,188 @@
+package mp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ridewindx/mel"
+)
+
+// DefaultMetricsBuckets are the upper bounds in seconds of the histogram buckets of the API call latencies.
+var DefaultMetricsBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+type apiCallKey struct {
+	endpoint string
+	errCode  string
+}
+
+// Metrics is an Observer which counts the events, and exposes them in Prometheus text format:
+//
+//	wechat_token_fetches_total{name}
+//	wechat_token_fetch_failures_total{name}
+//	wechat_token_forced_refreshes_total{name}
+//	wechat_api_calls_total{endpoint, errcode}, errcode is "error" if no response is decoded
+//	wechat_api_call_duration_seconds{endpoint}, a histogram
+type Metrics struct {
+	buckets []float64
+
+	mutex           sync.Mutex
+	fetches         map[string]uint64
+	fetchFailures   map[string]uint64
+	forcedRefreshes map[string]uint64
+	apiCalls        map[apiCallKey]uint64
+	latencies       map[string]*histogram
+}
+
+// NewMetrics creates the metrics, with DefaultMetricsBuckets if buckets are not specified.
+func NewMetrics(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultMetricsBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &Metrics{
+		buckets:         buckets,
+		fetches:         make(map[string]uint64),
+		fetchFailures:   make(map[string]uint64),
+		forcedRefreshes: make(map[string]uint64),
+		apiCalls:        make(map[apiCallKey]uint64),
+		latencies:       make(map[string]*histogram),
+	}
+}
+
+func (m *Metrics) TokenFetched(name string, expiresIn time.Duration) {
+	m.mutex.Lock()
+	m.fetches[name]++
+	m.mutex.Unlock()
+}
+
+func (m *Metrics) FetchFailed(name string, err error) {
+	m.mutex.Lock()
+	m.fetchFailures[name]++
+	m.mutex.Unlock()
+}
+
+func (m *Metrics) ForcedRefresh(name string) {
+	m.mutex.Lock()
+	m.forcedRefreshes[name]++
+	m.mutex.Unlock()
+}
+
+func (m *Metrics) APICallCompleted(endpoint string, errCode int, latency time.Duration, err error) {
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(errCode)
+	}
+	seconds := latency.Seconds()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.apiCalls[apiCallKey{endpoint, code}]++
+
+	h, ok := m.latencies[endpoint]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.latencies[endpoint] = h
+	}
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeCounters(w *bufio.Writer, name, help, label string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, labelValueReplacer.Replace(k), values[k])
+	}
+}
+
+// WriteText writes the metrics in Prometheus text format.
+func (m *Metrics) WriteText(out io.Writer) error {
+	w := bufio.NewWriter(out)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	writeCounters(w, "wechat_token_fetches_total", "Number of access token and ticket fetches.", "name", m.fetches)
+	writeCounters(w, "wechat_token_fetch_failures_total", "Number of failed access token and ticket fetches.", "name", m.fetchFailures)
+	writeCounters(w, "wechat_token_forced_refreshes_total", "Number of refreshes because the access token or ticket was rejected.", "name", m.forcedRefreshes)
+
+	keys := make([]apiCallKey, 0, len(m.apiCalls))
+	for k := range m.apiCalls {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].endpoint != keys[j].endpoint {
+			return keys[i].endpoint < keys[j].endpoint
+		}
+		return keys[i].errCode < keys[j].errCode
+	})
+	fmt.Fprint(w, "# HELP wechat_api_calls_total Number of API requests by errcode.\n# TYPE wechat_api_calls_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(w, "wechat_api_calls_total{endpoint=\"%s\",errcode=\"%s\"} %d\n",
+			labelValueReplacer.Replace(k.endpoint), k.errCode, m.apiCalls[k])
+	}
+
+	endpoints := make([]string, 0, len(m.latencies))
+	for endpoint := range m.latencies {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	fmt.Fprint(w, "# HELP wechat_api_call_duration_seconds Latency of API requests.\n# TYPE wechat_api_call_duration_seconds histogram\n")
+	for _, endpoint := range endpoints {
+		h := m.latencies[endpoint]
+		label := labelValueReplacer.Replace(endpoint)
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "wechat_api_call_duration_seconds_bucket{endpoint=\"%s\",le=\"%s\"} %d\n",
+				label, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "wechat_api_call_duration_seconds_bucket{endpoint=\"%s\",le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(w, "wechat_api_call_duration_seconds_sum{endpoint=\"%s\"} %s\n", label, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "wechat_api_call_duration_seconds_count{endpoint=\"%s\"} %d\n", label, h.count)
+	}
+
+	return w.Flush()
+}
+
+// ServeHTTP responds the metrics in Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteText(w)
+}
+
+// ServeMetrics serves the metrics at the /metrics route of the server, for Prometheus to scrape.
+// Set the metrics as the observer of the client by SetObserver, and restrict the access to the route if needed.
+func (srv *Server) ServeMetrics(m *Metrics) {
+	srv.Get(srv.urlPrefix+"/metrics", func(c *mel.Context) {
+		m.ServeHTTP(c.Writer, c.Request)
+	})
+}
@@ -0,0 +1,126 @@
+package mp
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsWriteText(t *testing.T) {
+	const emptyHeaders = `# HELP wechat_token_fetches_total Number of access token and ticket fetches.
+# TYPE wechat_token_fetches_total counter
+# HELP wechat_token_fetch_failures_total Number of failed access token and ticket fetches.
+# TYPE wechat_token_fetch_failures_total counter
+# HELP wechat_token_forced_refreshes_total Number of refreshes because the access token or ticket was rejected.
+# TYPE wechat_token_forced_refreshes_total counter
+# HELP wechat_api_calls_total Number of API requests by errcode.
+# TYPE wechat_api_calls_total counter
+# HELP wechat_api_call_duration_seconds Latency of API requests.
+# TYPE wechat_api_call_duration_seconds histogram
+`
+
+	tests := []struct {
+		name    string
+		observe func(m *Metrics)
+		want    string
+	}{
+		{
+			name:    "empty",
+			observe: func(m *Metrics) {},
+			want:    emptyHeaders,
+		},
+		{
+			name: "token counters",
+			observe: func(m *Metrics) {
+				m.TokenFetched(ObservedAccessToken, time.Hour)
+				m.TokenFetched(ObservedAccessToken, time.Hour)
+				m.TokenFetched(TicketJSAPI, time.Hour)
+				m.FetchFailed(`a"b\c`, errors.New("failed"))
+				m.ForcedRefresh(TicketJSAPI)
+			},
+			want: `# HELP wechat_token_fetches_total Number of access token and ticket fetches.
+# TYPE wechat_token_fetches_total counter
+wechat_token_fetches_total{name="` + ObservedAccessToken + `"} 2
+wechat_token_fetches_total{name="jsapi"} 1
+# HELP wechat_token_fetch_failures_total Number of failed access token and ticket fetches.
+# TYPE wechat_token_fetch_failures_total counter
+wechat_token_fetch_failures_total{name="a\"b\\c"} 1
+# HELP wechat_token_forced_refreshes_total Number of refreshes because the access token or ticket was rejected.
+# TYPE wechat_token_forced_refreshes_total counter
+wechat_token_forced_refreshes_total{name="jsapi"} 1
+# HELP wechat_api_calls_total Number of API requests by errcode.
+# TYPE wechat_api_calls_total counter
+# HELP wechat_api_call_duration_seconds Latency of API requests.
+# TYPE wechat_api_call_duration_seconds histogram
+`,
+		},
+		{
+			name: "api calls",
+			observe: func(m *Metrics) {
+				m.APICallCompleted("/user/info", OK, 250*time.Millisecond, nil)
+				m.APICallCompleted("/user/info", SystemBusy, 500*time.Millisecond, nil)
+				m.APICallCompleted("/user/info", OK, 2*time.Second, errors.New("timeout"))
+				m.APICallCompleted("/menu/get", OK, 750*time.Millisecond, nil)
+			},
+			want: `# HELP wechat_token_fetches_total Number of access token and ticket fetches.
+# TYPE wechat_token_fetches_total counter
+# HELP wechat_token_fetch_failures_total Number of failed access token and ticket fetches.
+# TYPE wechat_token_fetch_failures_total counter
+# HELP wechat_token_forced_refreshes_total Number of refreshes because the access token or ticket was rejected.
+# TYPE wechat_token_forced_refreshes_total counter
+# HELP wechat_api_calls_total Number of API requests by errcode.
+# TYPE wechat_api_calls_total counter
+wechat_api_calls_total{endpoint="/menu/get",errcode="0"} 1
+wechat_api_calls_total{endpoint="/user/info",errcode="-1"} 1
+wechat_api_calls_total{endpoint="/user/info",errcode="0"} 1
+wechat_api_calls_total{endpoint="/user/info",errcode="error"} 1
+# HELP wechat_api_call_duration_seconds Latency of API requests.
+# TYPE wechat_api_call_duration_seconds histogram
+wechat_api_call_duration_seconds_bucket{endpoint="/menu/get",le="0.5"} 0
+wechat_api_call_duration_seconds_bucket{endpoint="/menu/get",le="1"} 1
+wechat_api_call_duration_seconds_bucket{endpoint="/menu/get",le="+Inf"} 1
+wechat_api_call_duration_seconds_sum{endpoint="/menu/get"} 0.75
+wechat_api_call_duration_seconds_count{endpoint="/menu/get"} 1
+wechat_api_call_duration_seconds_bucket{endpoint="/user/info",le="0.5"} 2
+wechat_api_call_duration_seconds_bucket{endpoint="/user/info",le="1"} 2
+wechat_api_call_duration_seconds_bucket{endpoint="/user/info",le="+Inf"} 3
+wechat_api_call_duration_seconds_sum{endpoint="/user/info"} 2.75
+wechat_api_call_duration_seconds_count{endpoint="/user/info"} 3
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMetrics(1, 0.5)
+			tt.observe(m)
+
+			var buf bytes.Buffer
+			if err := m.WriteText(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsServeHTTP(t *testing.T) {
+	m := NewMetrics()
+	m.TokenFetched(ObservedAccessToken, time.Hour)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %s", ct)
+	}
+	if !strings.Contains(w.Body.String(), `wechat_token_fetches_total{name="`+ObservedAccessToken+`"} 1`) {
+		t.Errorf("body = %s", w.Body.String())
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"encoding/json"
 	"fmt"
+	"net/url"
 )
 
 func oauth2Get(client *http.Client, url string, result interface{}) error {
@@ -54,6 +55,7 @@ type Oauth2Token struct {
 	RefreshToken string `json:"refresh_token"`
 	OpenID       string `json:"openid"`
 	Scope        string `json:"scope"`
+	UnionID      string `json:"unionid,omitempty"`
 	State        string `json:"state,omitempty"`
 }
 
@@ -65,6 +67,8 @@ func (c *Client) Oauth2GetTokenAndRedirect(code, state, redirectURL string) (str
 	return c.Oauth2Redirect(token, redirectURL)
 }
 
+// Oauth2Redirect puts the token as JSON into the "wechat" query of redirectURL,
+// which may leak into logs and referers. Consider Oauth2Sessions instead.
 func (c *Client) Oauth2Redirect(token *Oauth2Token, redirectURL string) (string, error) {
 	data, err := json.Marshal(token)
 	if err != nil {
@@ -75,15 +79,17 @@ func (c *Client) Oauth2Redirect(token *Oauth2Token, redirectURL string) (string,
 }
 
 func (c *Client) Oauth2GetToken(code, state string) (*Oauth2Token, error) {
-	url := fmt.Sprintf("https://api.weixin.qq.com/sns/oauth2/access_token?appid=%s&secret=%s&code=%s&grant_type=authorization_code", c.appID, c.appSecret, code)
+	u := fmt.Sprintf("https://api.weixin.qq.com/sns/oauth2/access_token?appid=%s&secret=%s&code=%s&grant_type=authorization_code",
+		url.QueryEscape(c.appID), url.QueryEscape(c.appSecret), url.QueryEscape(code))
 
-	return oauth2GetToken(c.Client, url, state)
+	return oauth2GetToken(c.Client, u, state)
 }
 
 func (c *Client) Oauth2RefreshToken(refreshToken string) (*Oauth2Token, error) {
-	url := fmt.Sprintf("https://api.weixin.qq.com/sns/oauth2/refresh_token?appid=%s&grant_type=refresh_token&refresh_token=%s", c.appID, refreshToken)
+	u := fmt.Sprintf("https://api.weixin.qq.com/sns/oauth2/refresh_token?appid=%s&grant_type=refresh_token&refresh_token=%s",
+		url.QueryEscape(c.appID), url.QueryEscape(refreshToken))
 
-	return oauth2GetToken(c.Client, url, "")
+	return oauth2GetToken(c.Client, u, "")
 }
 
 type Oauth2User struct {
@@ -99,6 +105,10 @@ type Oauth2User struct {
 }
 
 func (c *Client) Oauth2GetUser(token, openID string) (*Oauth2User, error) {
+	return oauth2GetUser(c.Client, token, openID)
+}
+
+func oauth2GetUser(client *http.Client, token, openID string) (*Oauth2User, error) {
 	url := fmt.Sprintf("https://api.weixin.qq.com/sns/userinfo?access_token=%s&openid=%s&lang=zh_CN", token, openID)
 
 	type ResultWithErr struct {
@@ -107,9 +117,28 @@ func (c *Client) Oauth2GetUser(token, openID string) (*Oauth2User, error) {
 	}
 
 	var result ResultWithErr
-	err := oauth2Get(c.Client, url, &result)
+	err := oauth2Get(client, url, &result)
 	if err != nil {
 		return nil, err
 	}
 	return &result.Oauth2User, nil
 }
+
+// Oauth2CheckToken checks whether the user access token is still valid.
+func (c *Client) Oauth2CheckToken(token, openID string) (bool, error) {
+	return oauth2CheckToken(c.Client, token, openID)
+}
+
+func oauth2CheckToken(client *http.Client, token, openID string) (bool, error) {
+	url := string(URL("https://api.weixin.qq.com/sns/auth").Query("access_token", token).Query("openid", openID))
+
+	var result Err
+	err := oauth2Get(client, url, &result)
+	if err != nil {
+		if e, ok := err.(Error); ok && (e.Code() == InvalidCredential || e.Code() == InvalidOpenID || e.Code() == InvalidAccessToken || e.Code() == AccessTokenExpired) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
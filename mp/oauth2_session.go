@@ -0,0 +1,386 @@
+package mp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jiudaoyun/wechat"
+	"github.com/ridewindx/mel"
+	"go.uber.org/zap"
+)
+
+var ErrNoOauth2Session = errors.New("no valid oauth2 session")
+
+// Oauth2Session is the server side session of a user authorized by OAuth2.
+// The token is kept on the server, and only the signed session ID and the user IDs are sent to the browser.
+type Oauth2Session struct {
+	ID             string       `json:"id"`
+	OpenID         string       `json:"openid"`
+	UnionID        string       `json:"unionid,omitempty"`
+	Token          *Oauth2Token `json:"token"`
+	TokenExpiresAt time.Time    `json:"token_expires_at"` // expiry of the access token
+	CreatedAt      time.Time    `json:"created_at"`
+	ExpiresAt      time.Time    `json:"expires_at"` // expiry of the session
+}
+
+// Oauth2SessionStore persists the OAuth2 sessions.
+type Oauth2SessionStore interface {
+	GetOauth2Session(id string) (*Oauth2Session, error) // returns nil session if not found
+	PutOauth2Session(session *Oauth2Session) error
+	DeleteOauth2Session(id string) error
+}
+
+type MemoryOauth2SessionStore struct {
+	mutex    sync.RWMutex
+	sessions map[string]Oauth2Session
+}
+
+func NewMemoryOauth2SessionStore() *MemoryOauth2SessionStore {
+	return &MemoryOauth2SessionStore{
+		sessions: make(map[string]Oauth2Session),
+	}
+}
+
+func (s *MemoryOauth2SessionStore) GetOauth2Session(id string) (*Oauth2Session, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+func (s *MemoryOauth2SessionStore) PutOauth2Session(session *Oauth2Session) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// prune the expired sessions
+	now := time.Now()
+	for id, sess := range s.sessions {
+		if now.After(sess.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+
+	s.sessions[session.ID] = *session
+	return nil
+}
+
+func (s *MemoryOauth2SessionStore) DeleteOauth2Session(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// sessionClaims is the payload of the session cookie.
+type sessionClaims struct {
+	ID        string `json:"sid"`
+	OpenID    string `json:"openid"`
+	UnionID   string `json:"unionid,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Oauth2Sessions issues HMAC signed session cookies after OAuth2 authorization, authenticates the later requests
+// by the cookies, and refreshes the access tokens transparently.
+type Oauth2Sessions struct {
+	client *Client
+	store  Oauth2SessionStore
+	key    []byte
+	logger *zap.SugaredLogger
+
+	mutex      sync.Mutex
+	refreshing map[string]*sessionRefresh // the refreshes in flight by session ID
+
+	CookieName   string        // default "wechat_session"
+	CookiePath   string        // default "/"
+	CookieDomain string        // set it to share the session with the subdomains
+	Insecure     bool          // whether to send the cookie over HTTP, only for development
+	MaxAge       time.Duration // default 7 days, no more than the 30 days validity of the refresh token
+}
+
+// NewOauth2Sessions creates the sessions signed by key, which should be random and at least 32 bytes.
+func NewOauth2Sessions(client *Client, key []byte, store ...Oauth2SessionStore) *Oauth2Sessions {
+	s := &Oauth2Sessions{
+		client:     client,
+		key:        key,
+		logger:     wechat.Sugar,
+		refreshing: make(map[string]*sessionRefresh),
+		CookieName: "wechat_session",
+		CookiePath: "/",
+		MaxAge:     7 * 24 * time.Hour,
+	}
+	if len(store) > 0 {
+		s.store = store[0]
+	} else {
+		s.store = NewMemoryOauth2SessionStore()
+	}
+	return s
+}
+
+func (s *Oauth2Sessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Oauth2Sessions) encode(claims *sessionClaims) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + s.sign(payload), nil
+}
+
+func (s *Oauth2Sessions) decode(value string) (*sessionClaims, error) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return nil, ErrNoOauth2Session
+	}
+	payload, sig := value[:i], value[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(s.sign(payload))) {
+		return nil, ErrNoOauth2Session
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrNoOauth2Session
+	}
+	var claims sessionClaims
+	if err = json.Unmarshal(data, &claims); err != nil {
+		return nil, ErrNoOauth2Session
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrNoOauth2Session
+	}
+	return &claims, nil
+}
+
+// Issue creates a session of the token, and sets the session cookie.
+func (s *Oauth2Sessions) Issue(w http.ResponseWriter, token *Oauth2Token) (*Oauth2Session, error) {
+	id, err := newOauth2SessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &Oauth2Session{
+		ID:             id,
+		OpenID:         token.OpenID,
+		UnionID:        token.UnionID,
+		Token:          token,
+		TokenExpiresAt: now.Add(time.Duration(token.ExpiresIn) * time.Second),
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(s.MaxAge),
+	}
+	if err = s.store.PutOauth2Session(session); err != nil {
+		return nil, err
+	}
+
+	value, err := s.encode(&sessionClaims{
+		ID:        session.ID,
+		OpenID:    session.OpenID,
+		UnionID:   session.UnionID,
+		ExpiresAt: session.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.CookieName,
+		Value:    value,
+		Path:     s.CookiePath,
+		Domain:   s.CookieDomain,
+		Expires:  session.ExpiresAt,
+		MaxAge:   int(s.MaxAge / time.Second),
+		Secure:   !s.Insecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return session, nil
+}
+
+// Authenticate returns the session of the request, refreshing its access token if expired.
+// It returns ErrNoOauth2Session if the cookie is absent, invalid or expired, or the refresh token is rejected.
+// Other errors of refreshing are returned as is, and the session is kept.
+func (s *Oauth2Sessions) Authenticate(r *http.Request) (*Oauth2Session, error) {
+	cookie, err := r.Cookie(s.CookieName)
+	if err != nil {
+		return nil, ErrNoOauth2Session
+	}
+	claims, err := s.decode(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.store.GetOauth2Session(claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || time.Now().After(session.ExpiresAt) {
+		return nil, ErrNoOauth2Session
+	}
+
+	if time.Now().Add(time.Minute).After(session.TokenExpiresAt) {
+		return s.refresh(session.ID)
+	}
+	return session, nil
+}
+
+// sessionRefresh is a refresh of a session in flight, shared by the concurrent requests of the session.
+type sessionRefresh struct {
+	done    chan struct{}
+	session *Oauth2Session
+	err     error
+}
+
+// refresh refreshes the access token of the session. The concurrent refreshes of the same session
+// are coalesced, while the other sessions are not blocked.
+func (s *Oauth2Sessions) refresh(id string) (*Oauth2Session, error) {
+	s.mutex.Lock()
+	if call, ok := s.refreshing[id]; ok {
+		s.mutex.Unlock()
+		<-call.done
+		return call.session, call.err
+	}
+	call := &sessionRefresh{done: make(chan struct{})}
+	s.refreshing[id] = call
+	s.mutex.Unlock()
+
+	call.session, call.err = s.doRefresh(id)
+
+	s.mutex.Lock()
+	delete(s.refreshing, id)
+	s.mutex.Unlock()
+	close(call.done)
+	return call.session, call.err
+}
+
+func (s *Oauth2Sessions) doRefresh(id string) (*Oauth2Session, error) {
+	// reload as it may have been refreshed by another request
+	session, err := s.store.GetOauth2Session(id)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, ErrNoOauth2Session
+	}
+	if time.Now().Add(time.Minute).Before(session.TokenExpiresAt) {
+		return session, nil
+	}
+
+	token, err := s.client.Oauth2RefreshToken(session.Token.RefreshToken)
+	if err != nil {
+		if e, ok := err.(Error); ok && isRefreshTokenRejected(e.Code()) {
+			// the refresh token is expired or revoked, the user needs to authorize again
+			s.store.DeleteOauth2Session(id)
+			return nil, ErrNoOauth2Session
+		}
+		return nil, err
+	}
+	if token.UnionID == "" {
+		token.UnionID = session.UnionID
+	}
+
+	session.Token = token
+	session.TokenExpiresAt = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	if err = s.store.PutOauth2Session(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// isRefreshTokenRejected reports whether the errcode of refreshing means the refresh token
+// can never be used again, other errors, e.g. SystemBusy, may be transient.
+func isRefreshTokenRejected(code int) bool {
+	switch code {
+	case InvalidRefreshToken, RefreshTokenExpired, Oauth2CodeUsed:
+		return true
+	}
+	return false
+}
+
+// Revoke deletes the session of the request, and clears the cookie.
+func (s *Oauth2Sessions) Revoke(w http.ResponseWriter, r *http.Request) error {
+	http.SetCookie(w, &http.Cookie{
+		Name:   s.CookieName,
+		Path:   s.CookiePath,
+		Domain: s.CookieDomain,
+		MaxAge: -1,
+	})
+
+	cookie, err := r.Cookie(s.CookieName)
+	if err != nil {
+		return nil
+	}
+	claims, err := s.decode(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	return s.store.DeleteOauth2Session(claims.ID)
+}
+
+type oauth2SessionKey struct{}
+
+// Oauth2SessionFromContext returns the session set by the middlewares of Oauth2Sessions.
+func Oauth2SessionFromContext(ctx context.Context) *Oauth2Session {
+	session, _ := ctx.Value(oauth2SessionKey{}).(*Oauth2Session)
+	return session
+}
+
+// failedStatus returns 401 if the request has no valid session, otherwise logs err and returns 500,
+// e.g. the store or Wechat failed, which should not be exposed to the client.
+func (s *Oauth2Sessions) failedStatus(err error) int {
+	if err == ErrNoOauth2Session {
+		return http.StatusUnauthorized
+	}
+	s.logger.Errorw("Authenticate oauth2 session failed", "error", err)
+	return http.StatusInternalServerError
+}
+
+// Handler authenticates the requests to next, and responds 401 if not authenticated,
+// or 500 if the session can't be loaded or refreshed.
+// The session can be got by Oauth2SessionFromContext(r.Context()).
+func (s *Oauth2Sessions) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := s.Authenticate(r)
+		if err != nil {
+			status := s.failedStatus(err)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), oauth2SessionKey{}, session)))
+	})
+}
+
+// Middleware is the mel version of Handler.
+func (s *Oauth2Sessions) Middleware() mel.Handler {
+	return func(c *mel.Context) {
+		session, err := s.Authenticate(c.Request)
+		if err != nil {
+			c.AbortWithStatus(s.failedStatus(err))
+			return
+		}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), oauth2SessionKey{}, session))
+		c.Next()
+	}
+}
+
+// UseOauth2Sessions makes the /token route issue a session cookie and redirect without the token,
+// instead of putting the token into the query of the redirect URL.
+func (srv *Server) UseOauth2Sessions(sessions *Oauth2Sessions) {
+	srv.oauth2Sessions = sessions
+}
@@ -0,0 +1,173 @@
+package mp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// roundTripFunc serves the requests of an http.Client by a handler, whatever the host is.
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func handlerTransport(handler http.HandlerFunc) http.RoundTripper {
+	return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Result(), nil
+	})
+}
+
+func TestOauth2SessionsRefresh(t *testing.T) {
+	tests := []struct {
+		name        string
+		response    string
+		wantErr     error // nil if refreshed
+		wantDeleted bool
+	}{
+		{
+			name:     "refreshed",
+			response: `{"access_token":"new","expires_in":7200,"refresh_token":"refresh2","openid":"openid"}`,
+		},
+		{
+			name:        "refresh token invalid",
+			response:    `{"errcode":40030,"errmsg":"invalid refresh_token"}`,
+			wantErr:     ErrNoOauth2Session,
+			wantDeleted: true,
+		},
+		{
+			name:        "refresh token expired",
+			response:    `{"errcode":42002,"errmsg":"refresh_token expired"}`,
+			wantErr:     ErrNoOauth2Session,
+			wantDeleted: true,
+		},
+		{
+			name:     "system busy",
+			response: `{"errcode":-1,"errmsg":"system error"}`,
+			wantErr:  ErrSystemBusy,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			release := make(chan struct{})
+			client := NewClient("appid", "secret", false)
+			client.Client = &http.Client{Transport: handlerTransport(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				if r.URL.Query().Get("refresh_token") != "refresh1" {
+					t.Errorf("refresh_token = %s", r.URL.Query().Get("refresh_token"))
+				}
+				w.Write([]byte(tt.response))
+			})}
+
+			sessions := NewOauth2Sessions(client, []byte(strings.Repeat("k", 32)))
+			w := httptest.NewRecorder()
+			issued, err := sessions.Issue(w, &Oauth2Token{AccessToken: "old", ExpiresIn: 30, RefreshToken: "refresh1", OpenID: "openid"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cookie := w.Result().Cookies()[0]
+
+			const n = 5
+			var wg sync.WaitGroup
+			errs := make([]error, n)
+			results := make([]*Oauth2Session, n)
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					r := httptest.NewRequest(http.MethodGet, "/", nil)
+					r.AddCookie(cookie)
+					results[i], errs[i] = sessions.Authenticate(r)
+				}(i)
+			}
+			time.Sleep(50 * time.Millisecond) // let the requests wait on the same refresh
+			close(release)
+			wg.Wait()
+
+			if calls != 1 {
+				t.Errorf("refreshed %d times, want once", calls)
+			}
+			for i := 0; i < n; i++ {
+				if tt.wantErr == nil {
+					if errs[i] != nil || results[i].Token.AccessToken != "new" {
+						t.Errorf("request %d: %v, %+v", i, errs[i], results[i])
+					}
+				} else if !errors.Is(errs[i], tt.wantErr) {
+					t.Errorf("request %d: err = %v, want %v", i, errs[i], tt.wantErr)
+				}
+			}
+
+			stored, _ := sessions.store.GetOauth2Session(issued.ID)
+			if deleted := stored == nil; deleted != tt.wantDeleted {
+				t.Errorf("session deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+// failingOauth2SessionStore fails to get any session.
+type failingOauth2SessionStore struct {
+	*MemoryOauth2SessionStore
+}
+
+func (s failingOauth2SessionStore) GetOauth2Session(id string) (*Oauth2Session, error) {
+	return nil, errors.New("store unavailable: secret details")
+}
+
+func TestOauth2SessionsHandler(t *testing.T) {
+	key := []byte(strings.Repeat("k", 32))
+	token := &Oauth2Token{AccessToken: "token", ExpiresIn: 7200, RefreshToken: "refresh", OpenID: "openid"}
+
+	tests := []struct {
+		name       string
+		store      Oauth2SessionStore
+		cookie     bool
+		wantStatus int
+	}{
+		{name: "authenticated", store: NewMemoryOauth2SessionStore(), cookie: true, wantStatus: http.StatusOK},
+		{name: "no cookie", store: NewMemoryOauth2SessionStore(), wantStatus: http.StatusUnauthorized},
+		{name: "store failed", store: failingOauth2SessionStore{NewMemoryOauth2SessionStore()}, cookie: true, wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions := NewOauth2Sessions(NewClient("appid", "secret", false), key, tt.store)
+			sessions.logger = zap.NewNop().Sugar()
+
+			w := httptest.NewRecorder()
+			if _, err := sessions.Issue(w, token); err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.cookie {
+				r.AddCookie(w.Result().Cookies()[0])
+			}
+
+			w = httptest.NewRecorder()
+			sessions.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if session := Oauth2SessionFromContext(r.Context()); session == nil || session.OpenID != "openid" {
+					t.Errorf("session = %+v", session)
+				}
+			})).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if strings.Contains(w.Body.String(), "secret") {
+				t.Errorf("body exposes the error: %s", w.Body.String())
+			}
+		})
+	}
+}
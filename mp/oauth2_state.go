@@ -0,0 +1,301 @@
+package mp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes of OAuth2 authorization
+const (
+	Oauth2ScopeBase     = "snsapi_base"     // silent authorization, only OpenID is available
+	Oauth2ScopeUserInfo = "snsapi_userinfo" // the user confirms, and Oauth2GetUser is available
+)
+
+// Oauth2AuthorizeURL returns the URL which asks the user to authorize in Wechat,
+// then redirects to redirectURI with code and state.
+func (c *Client) Oauth2AuthorizeURL(redirectURI, scope, state string) string {
+	u := URL("https://open.weixin.qq.com/connect/oauth2/authorize").
+		Query("appid", c.appID).
+		Query("redirect_uri", redirectURI).
+		Query("response_type", "code").
+		Query("scope", scope).
+		Query("state", state)
+	return string(u) + "#wechat_redirect"
+}
+
+// Oauth2StateTTL is how long an OAuth2 state is valid.
+var Oauth2StateTTL = 10 * time.Minute
+
+// Oauth2State is saved with the state parameter of the authorization, to protect against CSRF.
+type Oauth2State struct {
+	RedirectURL string    `json:"redirect_url"` // where to redirect after authorized
+	Scope       string    `json:"scope"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Oauth2StateStore saves the states of the ongoing authorizations. A state can only be taken once.
+type Oauth2StateStore interface {
+	PutOauth2State(state string, data *Oauth2State) error
+	TakeOauth2State(state string) (*Oauth2State, error) // returns nil data if not found
+}
+
+type MemoryOauth2StateStore struct {
+	mutex  sync.Mutex
+	states map[string]Oauth2State
+}
+
+func NewMemoryOauth2StateStore() *MemoryOauth2StateStore {
+	return &MemoryOauth2StateStore{
+		states: make(map[string]Oauth2State),
+	}
+}
+
+func (s *MemoryOauth2StateStore) PutOauth2State(state string, data *Oauth2State) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// prune the expired states, which are never taken
+	for k, v := range s.states {
+		if time.Since(v.CreatedAt) >= Oauth2StateTTL {
+			delete(s.states, k)
+		}
+	}
+
+	s.states[state] = *data
+	return nil
+}
+
+func (s *MemoryOauth2StateStore) TakeOauth2State(state string) (*Oauth2State, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, ok := s.states[state]
+	if !ok {
+		return nil, nil
+	}
+	delete(s.states, state)
+	return &data, nil
+}
+
+// randomToken returns n random bytes in hex.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func newOauth2State() (string, error) {
+	return randomToken(16)
+}
+
+func newOauth2SessionID() (string, error) {
+	return randomToken(32)
+}
+
+// SetOauth2StateStore sets the store of OAuth2 states, default in memory.
+// Use a shared store if the server runs in multiple instances.
+func (srv *Server) SetOauth2StateStore(store Oauth2StateStore) {
+	srv.oauth2States = store
+}
+
+// SetOauth2CallbackURL sets the external URL of the /token route, which is the redirect_uri of authorization.
+// It must be on the host of the /authorize route, which sets the state cookie.
+// If not set, it is derived from the request to the /authorize route, see SetTrustedProxies.
+func (srv *Server) SetOauth2CallbackURL(callbackURL string) {
+	srv.oauth2CallbackURL = callbackURL
+}
+
+// AllowRedirectHosts allows redirecting to the hosts after authorized.
+// A host may be "example.com", or "*.example.com" to match its subdomains.
+// Relative URLs which have no host are always allowed.
+func (srv *Server) AllowRedirectHosts(hosts ...string) {
+	srv.redirectHostsMutex.Lock()
+	defer srv.redirectHostsMutex.Unlock()
+
+	srv.redirectHosts = append(srv.redirectHosts, hosts...)
+}
+
+// IsRedirectAllowed reports whether redirecting to redirectURL is allowed, see AllowRedirectHosts.
+func (srv *Server) IsRedirectAllowed(redirectURL string) bool {
+	if redirectURL == "" || strings.ContainsAny(redirectURL, "\\\r\n") {
+		return false
+	}
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		return false
+	}
+
+	if u.Scheme == "" && u.Host == "" {
+		// relative to the current host, but not protocol relative as "//evil.com"
+		return strings.HasPrefix(u.Path, "/") && !strings.HasPrefix(redirectURL, "//")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+
+	srv.redirectHostsMutex.RLock()
+	defer srv.redirectHostsMutex.RUnlock()
+
+	return matchHost(u.Hostname(), srv.redirectHosts)
+}
+
+// matchHost reports whether host matches any of the patterns,
+// which are exact hosts, or "*.example.com" matching the subdomains of example.com.
+func matchHost(host string, patterns []string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Oauth2AuthorizeURL saves a new state for redirectURL, and returns the authorize URL,
+// which redirects to the /token route of the server after authorized, then to redirectURL.
+// The state is bound to the browser by a cookie set on w, so w must be the response
+// which redirects the browser to the authorize URL.
+func (srv *Server) Oauth2AuthorizeURL(w http.ResponseWriter, redirectURL, scope string) (string, error) {
+	if srv.oauth2CallbackURL == "" {
+		return "", fmt.Errorf("oauth2 callback URL not set")
+	}
+	return srv.oauth2AuthorizeURL(w, srv.oauth2CallbackURL, redirectURL, scope)
+}
+
+func (srv *Server) oauth2AuthorizeURL(w http.ResponseWriter, callbackURL, redirectURL, scope string) (string, error) {
+	if scope == "" {
+		scope = Oauth2ScopeBase
+	}
+	if scope != Oauth2ScopeBase && scope != Oauth2ScopeUserInfo {
+		return "", fmt.Errorf("invalid oauth2 scope: %s", scope)
+	}
+	if !srv.IsRedirectAllowed(redirectURL) {
+		return "", fmt.Errorf("redirect URL not allowed: %s", redirectURL)
+	}
+	callback, err := url.Parse(callbackURL)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := newOauth2State()
+	if err != nil {
+		return "", err
+	}
+	err = srv.oauth2States.PutOauth2State(state, &Oauth2State{
+		RedirectURL: redirectURL,
+		Scope:       scope,
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     Oauth2StateCookie,
+		Value:    oauth2StateHash(state),
+		Path:     callback.Path,
+		MaxAge:   int(Oauth2StateTTL / time.Second),
+		Secure:   callback.Scheme == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode, // sent on the redirect back from Wechat
+	})
+
+	return srv.client.Oauth2AuthorizeURL(callbackURL, scope, state), nil
+}
+
+// Oauth2StateCookie is the name of the cookie which binds the OAuth2 state to the browser
+// starting the authorization, so that the code of another user can not be injected.
+const Oauth2StateCookie = "wechat_oauth2_state"
+
+func oauth2StateHash(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkOauth2StateCookie reports whether the state cookie of the request matches the state,
+// and clears the cookie.
+func checkOauth2StateCookie(w http.ResponseWriter, r *http.Request, state string) bool {
+	cookie, err := r.Cookie(Oauth2StateCookie)
+	if err != nil {
+		return false
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     Oauth2StateCookie,
+		Path:     r.URL.Path,
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(oauth2StateHash(state))) == 1
+}
+
+// takeOauth2State validates the state returned with the code, and returns its data.
+func (srv *Server) takeOauth2State(state string) (*Oauth2State, error) {
+	if state == "" {
+		return nil, fmt.Errorf("oauth2 state is empty")
+	}
+	data, err := srv.oauth2States.TakeOauth2State(state)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil || time.Since(data.CreatedAt) >= Oauth2StateTTL {
+		return nil, fmt.Errorf("oauth2 state invalid or expired: %s", state)
+	}
+	return data, nil
+}
+
+// SetTrustedProxies sets the IPs or CIDRs of the reverse proxies in front of the server,
+// whose X-Forwarded-Proto headers are trusted when deriving the OAuth2 callback URL.
+func (srv *Server) SetTrustedProxies(proxies ...string) error {
+	nets, err := parseIPNets(proxies)
+	if err != nil {
+		return err
+	}
+	srv.trustedProxies = nets
+	return nil
+}
+
+// requestCallbackURL derives the URL of the /token route from the request,
+// whose host must be allowed by AllowRedirectHosts.
+func (srv *Server) requestCallbackURL(r *http.Request) (string, error) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	srv.redirectHostsMutex.RLock()
+	allowed := host != "" && matchHost(host, srv.redirectHosts)
+	srv.redirectHostsMutex.RUnlock()
+	if !allowed {
+		return "", fmt.Errorf("host not allowed: %s", r.Host)
+	}
+
+	scheme := "http"
+	if r.TLS != nil || (srv.isTrustedProxy(r) && r.Header.Get("X-Forwarded-Proto") == "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + srv.urlPrefix + "/token", nil
+}
+
+func (srv *Server) isTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && containsIP(srv.trustedProxies, ip)
+}
@@ -0,0 +1,101 @@
+package mp
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestCallbackURL(t *testing.T) {
+	srv := &Server{urlPrefix: "/wechat"}
+	srv.AllowRedirectHosts("example.com")
+	if err := srv.SetTrustedProxies("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		host       string
+		remoteAddr string
+		proto      string
+		tls        bool
+		want       string // empty if not allowed
+	}{
+		{name: "http", host: "example.com", remoteAddr: "1.2.3.4:1234", want: "http://example.com/wechat/token"},
+		{name: "tls", host: "example.com:8443", remoteAddr: "1.2.3.4:1234", tls: true, want: "https://example.com:8443/wechat/token"},
+		{name: "trusted proxy", host: "example.com", remoteAddr: "10.1.2.3:1234", proto: "https", want: "https://example.com/wechat/token"},
+		{name: "untrusted proxy", host: "example.com", remoteAddr: "1.2.3.4:1234", proto: "https", want: "http://example.com/wechat/token"},
+		{name: "host not allowed", host: "evil.com", remoteAddr: "10.1.2.3:1234"},
+		{name: "empty host", host: "", remoteAddr: "1.2.3.4:1234"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/wechat/authorize", nil)
+			r.Host = tt.host
+			r.RemoteAddr = tt.remoteAddr
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+
+			got, err := srv.requestCallbackURL(r)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("got %s, want error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("got %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOauth2StateCookie(t *testing.T) {
+	srv := &Server{
+		client:       NewClient("appid", "secret", false),
+		oauth2States: NewMemoryOauth2StateStore(),
+	}
+
+	w := httptest.NewRecorder()
+	authorizeURL, err := srv.oauth2AuthorizeURL(w, "https://example.com/wechat/token", "/home", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != Oauth2StateCookie || !cookies[0].HttpOnly ||
+		!cookies[0].Secure || cookies[0].Path != "/wechat/token" {
+		t.Fatalf("cookies = %+v", cookies)
+	}
+
+	u, _ := http.NewRequest(http.MethodGet, authorizeURL, nil)
+	state := u.URL.Query().Get("state")
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		state  string
+		want   bool
+	}{
+		{name: "matched", cookie: cookies[0], state: state, want: true},
+		{name: "no cookie", state: state},
+		{name: "other state", cookie: cookies[0], state: "other"},
+		{name: "state as cookie", cookie: &http.Cookie{Name: Oauth2StateCookie, Value: state}, state: state},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/wechat/token?state="+tt.state, nil)
+			if tt.cookie != nil {
+				r.AddCookie(&http.Cookie{Name: tt.cookie.Name, Value: tt.cookie.Value})
+			}
+			if got := checkOauth2StateCookie(httptest.NewRecorder(), r, tt.state); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
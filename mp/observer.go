@@ -0,0 +1,94 @@
+package mp
+
+import (
+	"strings"
+	"time"
+)
+
+// ObservedAccessToken is the name of the access token in the events of Observer,
+// while the tickets are named by their types, e.g. TicketJSAPI.
+const ObservedAccessToken = "access_token"
+
+// Observer is notified of the events of TokenAccessor and Client, e.g. to log or to collect metrics.
+// The methods are called synchronously, possibly concurrently, and should return quickly.
+type Observer interface {
+	// TokenFetched is called when the access token or a ticket is fetched.
+	TokenFetched(name string, expiresIn time.Duration)
+	// FetchFailed is called when fetching the access token or a ticket failed.
+	FetchFailed(name string, err error)
+	// ForcedRefresh is called when the access token or a ticket is refreshed because it is rejected,
+	// e.g. an API call returned 40001 or 42001, before fetching.
+	ForcedRefresh(name string)
+	// APICallCompleted is called after each request of an API call, including the retry after refreshing the token.
+	// endpoint is the URL without the query. err is the error of the request or of decoding the response,
+	// otherwise errCode is the errcode of the response.
+	APICallCompleted(endpoint string, errCode int, latency time.Duration, err error)
+}
+
+type nopObserver struct{}
+
+func (nopObserver) TokenFetched(name string, expiresIn time.Duration)                               {}
+func (nopObserver) FetchFailed(name string, err error)                                              {}
+func (nopObserver) ForcedRefresh(name string)                                                       {}
+func (nopObserver) APICallCompleted(endpoint string, errCode int, latency time.Duration, err error) {}
+
+type multiObserver []Observer
+
+// MultiObserver returns an observer notifying all the observers in order.
+func MultiObserver(observers ...Observer) Observer {
+	return multiObserver(observers)
+}
+
+func (m multiObserver) TokenFetched(name string, expiresIn time.Duration) {
+	for _, o := range m {
+		o.TokenFetched(name, expiresIn)
+	}
+}
+
+func (m multiObserver) FetchFailed(name string, err error) {
+	for _, o := range m {
+		o.FetchFailed(name, err)
+	}
+}
+
+func (m multiObserver) ForcedRefresh(name string) {
+	for _, o := range m {
+		o.ForcedRefresh(name)
+	}
+}
+
+func (m multiObserver) APICallCompleted(endpoint string, errCode int, latency time.Duration, err error) {
+	for _, o := range m {
+		o.APICallCompleted(endpoint, errCode, latency, err)
+	}
+}
+
+// observerBox keeps the type stored in atomic.Value consistent.
+type observerBox struct {
+	Observer
+}
+
+// SetObserver sets the observer of the token, the tickets, and the API calls of the Client embedding the accessor.
+// A nil observer removes the current one.
+func (ta *TokenAccessor) SetObserver(o Observer) {
+	if o == nil {
+		o = nopObserver{}
+	}
+	ta.observer.Store(observerBox{o})
+}
+
+func (ta *TokenAccessor) getObserver() Observer {
+	if box, ok := ta.observer.Load().(observerBox); ok {
+		return box.Observer
+	}
+	return nopObserver{}
+}
+
+// endpointOf returns the URL without the query.
+func endpointOf(u URL) string {
+	s := string(u)
+	if i := strings.IndexByte(s, '?'); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
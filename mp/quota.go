@@ -0,0 +1,125 @@
+package mp
+
+// ClearQuota resets the daily quotas of all the APIs of the app, e.g. after APIQuotaExceeded.
+// It can only be called 10 times per month.
+func (c *Client) ClearQuota() error {
+	u := BASE_URL.Join("/clear_quota")
+
+	var req = struct {
+		AppID string `json:"appid"`
+	}{
+		AppID: c.appID,
+	}
+
+	var rep Err
+	err := c.Post(u, &req, &rep)
+	return err
+}
+
+type Quota struct {
+	DailyLimit int `json:"daily_limit"`
+	Used       int `json:"used"`
+	Remain     int `json:"remain"`
+}
+
+type RateLimit struct {
+	CallCount     int `json:"call_count"`     // calls allowed in the period
+	RefreshSecond int `json:"refresh_second"` // the period in seconds
+}
+
+type APIQuota struct {
+	Quota              Quota      `json:"quota"`
+	RateLimit          *RateLimit `json:"rate_limit,omitempty"`
+	ComponentRateLimit *RateLimit `json:"component_rate_limit,omitempty"` // when called by the third party platform
+}
+
+// GetQuota returns the quota of the API of cgiPath, e.g. "/cgi-bin/message/custom/send".
+func (c *Client) GetQuota(cgiPath string) (quota *APIQuota, err error) {
+	u := BASE_URL.Join("/openapi/quota/get")
+
+	var req = struct {
+		CGIPath string `json:"cgi_path"`
+	}{
+		CGIPath: cgiPath,
+	}
+
+	var rep struct {
+		Err
+		APIQuota
+	}
+	err = c.Post(u, &req, &rep)
+	if err != nil {
+		return
+	}
+
+	quota = &rep.APIQuota
+	return
+}
+
+// RidRequest is the request looked up by its rid.
+type RidRequest struct {
+	InvokeTime   int64  `json:"invoke_time"`
+	CostInMs     int64  `json:"cost_in_ms"`
+	RequestURL   string `json:"request_url"`
+	RequestBody  string `json:"request_body"`
+	ResponseBody string `json:"response_body"`
+	ClientIP     string `json:"client_ip"`
+}
+
+// GetRid looks up the failed request by the rid of its error, see Err.RequestID.
+// Only the requests of the recent 7 days can be looked up.
+func (c *Client) GetRid(rid string) (request *RidRequest, err error) {
+	u := BASE_URL.Join("/openapi/rid/get")
+
+	var req = struct {
+		Rid string `json:"rid"`
+	}{
+		Rid: rid,
+	}
+
+	var rep struct {
+		Err
+		Request RidRequest `json:"request"`
+	}
+	err = c.Post(u, &req, &rep)
+	if err != nil {
+		return
+	}
+
+	request = &rep.Request
+	return
+}
+
+// GetCallbackIP returns the IPs and CIDRs which Wechat sends the callback requests from.
+func (c *Client) GetCallbackIP() (ipList []string, err error) {
+	u := BASE_URL.Join("/getcallbackip")
+
+	var rep struct {
+		Err
+		IPList []string `json:"ip_list"`
+	}
+	err = c.Get(u, &rep)
+	if err != nil {
+		return
+	}
+
+	ipList = rep.IPList
+	return
+}
+
+// GetAPIDomainIP returns the IPs of the API domain api.weixin.qq.com, e.g. to configure the firewall.
+func (c *Client) GetAPIDomainIP() (ipList []string, err error) {
+	u := BASE_URL.Join("/get_api_domain_ip")
+
+	var rep struct {
+		Err
+		IPList []string `json:"ip_list"`
+	}
+	err = c.Get(u, &rep)
+	if err != nil {
+		return
+	}
+
+	ipList = rep.IPList
+	return
+}
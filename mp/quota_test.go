@@ -0,0 +1,66 @@
+package mp
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestParseRid(t *testing.T) {
+	tests := []struct {
+		errMsg string
+		want   string
+	}{
+		{"invalid credential, access_token is invalid or not latest rid: 5f3d8c1a-2b4e6f80-1a2b3c4d", "5f3d8c1a-2b4e6f80-1a2b3c4d"},
+		{"system error rid:61d2a8b0-0a1b2c3d-4e5f6a7b", "61d2a8b0-0a1b2c3d-4e5f6a7b"},
+		{"invalid openid rid: 5f3d8c1a-2b4e6f80-1a2b3c4d, hints: [ req_id: abc ]", "5f3d8c1a-2b4e6f80-1a2b3c4d"},
+		{"ok", ""},
+		{"invalid appid", ""},
+		{"grid: 1", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := parseRid(tt.errMsg); got != tt.want {
+			t.Errorf("parseRid(%q) = %q, want %q", tt.errMsg, got, tt.want)
+		}
+	}
+}
+
+func TestGetRid(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cgi-bin/openapi/rid/get":
+			var req struct {
+				Rid string `json:"rid"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Rid != "5f3d8c1a-2b4e6f80-1a2b3c4d" {
+				t.Errorf("rid = %s", req.Rid)
+			}
+			w.Write([]byte(`{"errcode":0,"errmsg":"ok","request":{"invoke_time":1635156704,"cost_in_ms":30,"request_url":"access_token=xxx","request_body":"","response_body":"{\"errcode\":45009}","client_ip":"1.2.3.4"}}`))
+		default:
+			w.Write([]byte(`{"errcode":45009,"errmsg":"reach max api daily quota limit rid: 5f3d8c1a-2b4e6f80-1a2b3c4d"}`))
+		}
+	})
+
+	var rep struct {
+		Err
+	}
+	err := c.Get(BASE_URL.Join("/user/info"), &rep)
+	e, ok := err.(RequestIDer)
+	if !ok {
+		t.Fatalf("err = %v, want RequestIDer", err)
+	}
+	if rep.Rid != "5f3d8c1a-2b4e6f80-1a2b3c4d" || e.RequestID() != rep.Rid {
+		t.Fatalf("rid = %q, RequestID() = %q", rep.Rid, e.RequestID())
+	}
+
+	request, err := c.GetRid(e.RequestID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.CostInMs != 30 || request.ClientIP != "1.2.3.4" || request.ResponseBody != `{"errcode":45009}` {
+		t.Errorf("request = %+v", request)
+	}
+}
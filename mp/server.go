@@ -16,6 +16,7 @@ import (
 	"unsafe"
 	"go.uber.org/zap"
 	"github.com/jiudaoyun/wechat"
+	"net"
 	"net/http"
 	"strings"
 	"github.com/ridewindx/melware"
@@ -35,6 +36,16 @@ type Server struct {
 	aesKey      unsafe.Pointer
 
 	client            *Client
+
+	oauth2States       Oauth2StateStore
+	oauth2CallbackURL  string
+	trustedProxies     []*net.IPNet
+	redirectHostsMutex sync.RWMutex
+	redirectHosts      []string
+	oauth2Sessions     *Oauth2Sessions
+
+	callbackIPs *CallbackIPVerifier
+
 	middlewares       []Handler
 	messageHandlerMap map[string]Handler
 	eventHandlerMap   map[string]Handler
@@ -189,6 +200,7 @@ func NewServer(token, aesKey string, urlPrefix ...string) *Server {
 		messageHandlerMap: make(map[string]Handler),
 		eventHandlerMap:   make(map[string]Handler),
 		logger:            wechat.Sugar,
+		oauth2States:      NewMemoryOauth2StateStore(),
 	}
 
 	srv.SetToken(token)
@@ -240,6 +252,18 @@ func NewServer(token, aesKey string, urlPrefix ...string) *Server {
 		return verifySignReturnToken(signature, timestamp, nonce) != ""
 	}
 
+	fromWechat := func(c *mel.Context) bool {
+		if srv.callbackIPs == nil {
+			return true
+		}
+		ip, ok := srv.callbackIPs.Verify(c.Request)
+		if !ok {
+			srv.callbackIPs.reject(c.Request, ip)
+			c.AbortWithStatus(http.StatusForbidden)
+		}
+		return ok
+	}
+
 	type EncryptMsg struct {
 		ToUserName string `xml:"ToUserName"`
 		Encrypt    string `xml:"Encrypt"`
@@ -250,6 +274,9 @@ func NewServer(token, aesKey string, urlPrefix ...string) *Server {
 	})
 
 	srv.Get(srv.urlPrefix+"/", func(c *mel.Context) {
+		if !fromWechat(c) {
+			return
+		}
 		if verifySign(c) {
 			echostr := c.Query("echostr")
 			c.Text(200, echostr)
@@ -281,6 +308,10 @@ func NewServer(token, aesKey string, urlPrefix ...string) *Server {
 	}
 
 	srv.Post(srv.urlPrefix+"/", func(c *mel.Context) {
+		if !fromWechat(c) {
+			return
+		}
+
 		encryptType := c.Query("encrypt_type")
 		signature := c.Query("signature")
 		timestamp := c.Query("timestamp")
@@ -388,12 +419,64 @@ func NewServer(token, aesKey string, urlPrefix ...string) *Server {
 		}
 	})
 
+	srv.Get(srv.urlPrefix+"/authorize", func(c *mel.Context) {
+		callbackURL := srv.oauth2CallbackURL
+		if callbackURL == "" {
+			var err error
+			if callbackURL, err = srv.requestCallbackURL(c.Request); err != nil {
+				c.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		authorizeURL, err := srv.oauth2AuthorizeURL(c.Writer, callbackURL, c.Query("url"), c.Query("scope"))
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		c.Redirect(http.StatusFound, authorizeURL)
+	})
+
+	// The state must be issued by Oauth2AuthorizeURL or the /authorize route to the same browser,
+	// and the redirect URL is the one saved with the state.
 	srv.Get(srv.urlPrefix+"/token", func(c *mel.Context) {
 		code := c.Query("code")
 		state := c.Query("state")
-		redirectURL := c.Query("url")
 
-		redirectURL, err := srv.client.Oauth2GetTokenAndRedirect(code, state, redirectURL)
+		if !checkOauth2StateCookie(c.Writer, c.Request, state) {
+			srv.logger.Warnw("Oauth2 state cookie mismatch", "state", state)
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		data, err := srv.takeOauth2State(state)
+		if err != nil {
+			srv.logger.Warnw("Invalid oauth2 state", "state", state, "error", err)
+			c.AbortWithError(http.StatusForbidden, err)
+			return
+		}
+		if !srv.IsRedirectAllowed(data.RedirectURL) {
+			srv.logger.Warnw("Redirect URL not allowed", "url", data.RedirectURL)
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		if srv.oauth2Sessions != nil {
+			token, err := srv.client.Oauth2GetToken(code, state)
+			if err != nil {
+				c.AbortWithError(http.StatusUnauthorized, err)
+				return
+			}
+			if _, err = srv.oauth2Sessions.Issue(c.Writer, token); err != nil {
+				c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+			c.Redirect(http.StatusFound, data.RedirectURL)
+			return
+		}
+
+		redirectURL, err := srv.client.Oauth2GetTokenAndRedirect(code, state, data.RedirectURL)
 		if err != nil {
 			c.AbortWithError(http.StatusUnauthorized, err)
 			return
@@ -429,16 +512,34 @@ func NewServer(token, aesKey string, urlPrefix ...string) *Server {
 		c.JSON(http.StatusOK, user)
 	})
 
+	srv.Get(srv.urlPrefix+"/jssdk-config", func(c *mel.Context) {
+		var apiList []string
+		if apis := c.Query("apis"); apis != "" {
+			apiList = strings.Split(apis, ",")
+		}
+
+		config, err := srv.client.JSSDKConfig(c.Query("url"), apiList)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		config.Debug = isTrue(c.Query("debug"))
+
+		c.JSON(http.StatusOK, config)
+	})
+
 	srv.Get(srv.urlPrefix+"/signature", func(c *mel.Context) {
 		timestamp := c.Query("timestamp")
 		noncestr := c.Query("noncestr")
-		url := c.Query("url")
-		refresh := c.Query("refresh")
+		url, err := srv.client.checkJSSDKURL(c.Query("url"))
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
 
 		var ticket string
-		var err error
-		if refresh != "" && (refresh == "true" || refresh == "True" || refresh == "1") {
-			ticket, err = srv.client.RefreshTicket("")
+		if isTrue(c.Query("refresh")) {
+			ticket, err = srv.client.ForceRefreshTicket()
 		} else {
 			ticket, err = srv.client.Ticket()
 		}
@@ -447,31 +548,18 @@ func NewServer(token, aesKey string, urlPrefix ...string) *Server {
 			return
 		}
 
-		strs := sort.StringSlice{
-			"timestamp=" + timestamp,
-			"noncestr=" + noncestr,
-			"url=" + url,
-			"jsapi_ticket=" + ticket,
-		}
-		strs.Sort()
-		h := sha1.New()
-		buf := bufio.NewWriterSize(h, 1024)
-		for i, s := range strs {
-			buf.WriteString(s)
-			if i < len(strs)-1 {
-				buf.WriteByte('&')
-			}
-		}
-		buf.Flush()
-		sign := hex.EncodeToString(h.Sum(nil))
 		c.JSON(http.StatusOK, map[string]string{
-			"signature": sign,
+			"signature": jssdkSignature(ticket, noncestr, timestamp, url),
 		})
 	})
 
 	return srv
 }
 
+func isTrue(s string) bool {
+	return s == "true" || s == "True" || s == "1"
+}
+
 func computeSign(elements ...string) string {
 	strs := sort.StringSlice(elements)
 	strs.Sort()
@@ -0,0 +1,304 @@
+package mp
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+const (
+	SubscribeAccept = "accept"
+	SubscribeReject = "reject"
+)
+
+// SubscribeMsgChange is an item of subscribe_msg_popup_event or subscribe_msg_change_event.
+type SubscribeMsgChange struct {
+	TemplateID string `xml:"TemplateId"            json:"TemplateId"`
+	Status     string `xml:"SubscribeStatusString" json:"SubscribeStatusString"` // accept or reject
+	PopupScene int    `xml:"PopupScene"            json:"PopupScene"`            // only for popup event, 1: mp, 2: page
+}
+
+// SubscribeMsgSent is an item of subscribe_msg_sent_event.
+type SubscribeMsgSent struct {
+	TemplateID  string `xml:"TemplateId"  json:"TemplateId"`
+	MsgID       int64  `xml:"MsgID"       json:"MsgID"`
+	ErrorCode   int    `xml:"ErrorCode"   json:"ErrorCode"`
+	ErrorStatus string `xml:"ErrorStatus" json:"ErrorStatus"`
+}
+
+// SubscribeMsgChanges returns the items of subscribe_msg_popup_event or subscribe_msg_change_event.
+func (e *Event) SubscribeMsgChanges() []SubscribeMsgChange {
+	switch e.Event {
+	case EventSubscribeMsgPopup:
+		return e.SubscribeMsgPopupEvent
+	case EventSubscribeMsgChange:
+		return e.SubscribeMsgChangeEvent
+	}
+	return nil
+}
+
+// SubscribeOnceAuthURL returns the URL which asks the user to authorize one-time subscription message.
+// After the user confirms or cancels, it redirects to redirectURL, see ParseSubscribeOnceAuth.
+func (c *Client) SubscribeOnceAuthURL(templateID string, scene int, redirectURL, reserved string) string {
+	u := URL("https://mp.weixin.qq.com/mp/subscribemsg").
+		Query("action", "get_confirm").
+		Query("appid", c.appID).
+		Query("scene", strconv.Itoa(scene)).
+		Query("template_id", templateID).
+		Query("redirect_url", redirectURL)
+	if reserved != "" {
+		u = u.Query("reserved", reserved)
+	}
+	return string(u) + "#wechat_redirect"
+}
+
+type SubscribeOnceAuth struct {
+	OpenID     string
+	TemplateID string
+	Action     string // confirm or cancel
+	Scene      int
+	Reserved   string
+}
+
+func (a *SubscribeOnceAuth) Confirmed() bool {
+	return a.Action == "confirm"
+}
+
+// ParseSubscribeOnceAuth parses the query of the redirect URL of SubscribeOnceAuthURL.
+func ParseSubscribeOnceAuth(query url.Values) (*SubscribeOnceAuth, error) {
+	auth := &SubscribeOnceAuth{
+		OpenID:     query.Get("openid"),
+		TemplateID: query.Get("template_id"),
+		Action:     query.Get("action"),
+		Reserved:   query.Get("reserved"),
+	}
+	if auth.OpenID == "" || auth.TemplateID == "" {
+		return nil, fmt.Errorf("invalid subscribe message auth: %s", query.Encode())
+	}
+
+	if scene := query.Get("scene"); scene != "" {
+		var err error
+		auth.Scene, err = strconv.Atoi(scene)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subscribe message auth scene: %s", scene)
+		}
+	}
+	return auth, nil
+}
+
+type MiniProgramPage struct {
+	AppID    string `json:"appid"`
+	PagePath string `json:"pagepath"`
+}
+
+// SubscribeOnceMsg is the one-time subscription message, which can be sent once for each authorization.
+type SubscribeOnceMsg struct {
+	ToUser      string           `json:"touser"`
+	TemplateID  string           `json:"template_id"`
+	URL         string           `json:"url,omitempty"`
+	MiniProgram *MiniProgramPage `json:"miniprogram,omitempty"`
+	Scene       int              `json:"scene,string"`
+	Title       string           `json:"title"` // less than 15 chars
+	Content     TemplateMsgValue `json:"-"`     // less than 200 chars
+}
+
+func (c *Client) SendSubscribeOnceMessage(msg *SubscribeOnceMsg) error {
+	u := BASE_URL.Join("/message/template/subscribe")
+
+	var req = struct {
+		*SubscribeOnceMsg
+		Data struct {
+			Content TemplateMsgValue `json:"content"`
+		} `json:"data"`
+	}{
+		SubscribeOnceMsg: msg,
+	}
+	req.Data.Content = msg.Content
+
+	var rep Err
+	return c.Post(u, &req, &rep)
+}
+
+// SubscribeMsg is the long-term subscribe message, which can be sent if the user accepts the template.
+type SubscribeMsg struct {
+	ToUser      string                      `json:"touser"`
+	TemplateID  string                      `json:"template_id"`
+	Page        string                      `json:"page,omitempty"`
+	MiniProgram *MiniProgramPage            `json:"miniprogram,omitempty"`
+	Data        map[string]TemplateMsgValue `json:"data"` // only Value is used
+}
+
+func (c *Client) SendSubscribeMessage(msg *SubscribeMsg, values ...map[string]string) error {
+	if msg.Data == nil && len(values) > 0 {
+		msg.Data = make(map[string]TemplateMsgValue)
+		for k, v := range values[0] {
+			msg.Data[k] = TemplateMsgValue{Value: v}
+		}
+	}
+
+	u := BASE_URL.Join("/message/subscribe/bizsend")
+
+	var rep Err
+	return c.Post(u, msg, &rep)
+}
+
+type SubscribeTemplate struct {
+	ID      string `json:"priTmplId"`
+	Title   string `json:"title"`
+	Content string `json:"content"` // e.g. "{{thing1.DATA}}\n{{time2.DATA}}", see ParseTemplateKeys
+	Example string `json:"example"`
+	Type    int    `json:"type"` // 2: one-time, 3: long-term
+}
+
+func (c *Client) GetSubscribeTemplates() ([]SubscribeTemplate, error) {
+	u := WXA_BASE_URL.Join("/newtmpl/gettemplate")
+
+	var rep struct {
+		Err
+		Templates []SubscribeTemplate `json:"data"`
+	}
+
+	err := c.Get(u, &rep)
+	if err != nil {
+		return nil, err
+	}
+
+	return rep.Templates, nil
+}
+
+// AddSubscribeTemplate adds the public template tid with the keywords kidList to the account.
+func (c *Client) AddSubscribeTemplate(tid string, kidList []int, sceneDesc string) (templateID string, err error) {
+	u := WXA_BASE_URL.Join("/newtmpl/addtemplate")
+
+	var req = struct {
+		TID       string `json:"tid"`
+		KidList   []int  `json:"kidList"`
+		SceneDesc string `json:"sceneDesc,omitempty"`
+	}{
+		TID:       tid,
+		KidList:   kidList,
+		SceneDesc: sceneDesc,
+	}
+
+	var rep struct {
+		Err
+		TemplateID string `json:"priTmplId"`
+	}
+
+	err = c.Post(u, &req, &rep)
+	if err != nil {
+		return
+	}
+
+	templateID = rep.TemplateID
+	return
+}
+
+func (c *Client) DeleteSubscribeTemplate(templateID string) error {
+	u := WXA_BASE_URL.Join("/newtmpl/deltemplate")
+
+	var req = struct {
+		TemplateID string `json:"priTmplId"`
+	}{
+		TemplateID: templateID,
+	}
+
+	var rep Err
+	return c.Post(u, &req, &rep)
+}
+
+type SubscribeCategory struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func (c *Client) GetSubscribeCategories() ([]SubscribeCategory, error) {
+	u := WXA_BASE_URL.Join("/newtmpl/getcategory")
+
+	var rep struct {
+		Err
+		Categories []SubscribeCategory `json:"data"`
+	}
+
+	err := c.Get(u, &rep)
+	if err != nil {
+		return nil, err
+	}
+
+	return rep.Categories, nil
+}
+
+// SubscriptionStore records the long-term subscribe message templates accepted by users.
+type SubscriptionStore interface {
+	SetSubscription(openID, templateID string, accepted bool) error
+	AcceptedTemplates(openID string) ([]string, error)
+}
+
+type MemorySubscriptionStore struct {
+	mutex     sync.RWMutex
+	templates map[string]map[string]bool
+}
+
+func NewMemorySubscriptionStore() *MemorySubscriptionStore {
+	return &MemorySubscriptionStore{
+		templates: make(map[string]map[string]bool),
+	}
+}
+
+func (s *MemorySubscriptionStore) SetSubscription(openID, templateID string, accepted bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m, ok := s.templates[openID]
+	if !ok {
+		if !accepted {
+			return nil
+		}
+		m = make(map[string]bool)
+		s.templates[openID] = m
+	}
+	if accepted {
+		m[templateID] = true
+	} else {
+		delete(m, templateID)
+	}
+	return nil
+}
+
+func (s *MemorySubscriptionStore) AcceptedTemplates(openID string) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	templateIDs := make([]string, 0, len(s.templates[openID]))
+	for id := range s.templates[openID] {
+		templateIDs = append(templateIDs, id)
+	}
+	return templateIDs, nil
+}
+
+type SubscribeMsgHandler func(ctx *Context, changes []SubscribeMsgChange)
+
+// HandleSubscribeMsgEvent handles subscribe_msg_popup_event and subscribe_msg_change_event.
+// If store is not nil, the changes are recorded into it before calling handler, which may be nil.
+func (srv *Server) HandleSubscribeMsgEvent(store SubscriptionStore, handler SubscribeMsgHandler) {
+	h := func(ctx *Context) {
+		changes := ctx.SubscribeMsgChanges()
+
+		if store != nil {
+			for _, change := range changes {
+				err := store.SetSubscription(ctx.FromUser, change.TemplateID, change.Status == SubscribeAccept)
+				if err != nil {
+					srv.logger.Errorw("Record subscription failed", "openid", ctx.FromUser, "error", err)
+				}
+			}
+		}
+
+		if handler != nil {
+			handler(ctx, changes)
+		}
+	}
+
+	srv.HandleEvent(EventSubscribeMsgPopup, h)
+	srv.HandleEvent(EventSubscribeMsgChange, h)
+}
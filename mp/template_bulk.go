@@ -0,0 +1,286 @@
+package mp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// isRetryableSendErr reports whether sending can be retried later.
+// Errors which are not *Err (e.g. network errors) are only retryable if retryTransportErrs,
+// since the message may have been sent, e.g. timed out after the request was sent.
+func isRetryableSendErr(err error, retryTransportErrs bool) bool {
+	e, ok := err.(Error)
+	if !ok {
+		return retryTransportErrs
+	}
+	switch ErrClassOf(e.Code()) {
+	case ErrClassRetryable, ErrClassQuota:
+		return true
+	}
+	return false
+}
+
+type BulkTemplateRecipient struct {
+	ToUser string            `json:"touser"`
+	Values map[string]string `json:"values,omitempty"` // overrides the values of the job template data
+}
+
+// BulkTemplateJob sends the same template message to many recipients.
+// The job ID identifies the job in the store, so that an interrupted job can be resumed.
+type BulkTemplateJob struct {
+	ID         string
+	Template   TemplateMsg // ToUser is ignored
+	Recipients []BulkTemplateRecipient
+}
+
+func (job *BulkTemplateJob) message(r *BulkTemplateRecipient) *TemplateMsg {
+	msg := job.Template
+	msg.ToUser = r.ToUser
+	msg.Data = make(map[string]TemplateMsgValue, len(job.Template.Data)+len(r.Values))
+	for k, v := range job.Template.Data {
+		msg.Data[k] = v
+	}
+	for k, v := range r.Values {
+		value := msg.Data[k]
+		value.Value = v
+		msg.Data[k] = value
+	}
+	return &msg
+}
+
+// BulkSendResult is the result of sending to one recipient.
+type BulkSendResult struct {
+	Index     int       `json:"index"` // index of the recipient in the job
+	ToUser    string    `json:"touser"`
+	MsgID     int64     `json:"msgid,omitempty"`
+	ErrCode   int       `json:"errcode,omitempty"`
+	ErrMsg    string    `json:"errmsg,omitempty"`
+	Retryable bool      `json:"retryable,omitempty"` // failed with a retryable error after all retries, will be sent again when resumed
+	Attempts  int       `json:"attempts"`
+	Time      time.Time `json:"time"`
+}
+
+func (r *BulkSendResult) Succeeded() bool {
+	return r.ErrCode == OK && r.ErrMsg == ""
+}
+
+// BulkSendStore persists the per-recipient results of bulk send jobs.
+type BulkSendStore interface {
+	LoadResults(jobID string) ([]BulkSendResult, error)
+	SaveResult(jobID string, result *BulkSendResult) error
+}
+
+type MemoryBulkSendStore struct {
+	mutex   sync.Mutex
+	results map[string]map[int]BulkSendResult
+}
+
+func NewMemoryBulkSendStore() *MemoryBulkSendStore {
+	return &MemoryBulkSendStore{
+		results: make(map[string]map[int]BulkSendResult),
+	}
+}
+
+func (s *MemoryBulkSendStore) LoadResults(jobID string) ([]BulkSendResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	results := make([]BulkSendResult, 0, len(s.results[jobID]))
+	for _, r := range s.results[jobID] {
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func (s *MemoryBulkSendStore) SaveResult(jobID string, result *BulkSendResult) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m, ok := s.results[jobID]
+	if !ok {
+		m = make(map[int]BulkSendResult)
+		s.results[jobID] = m
+	}
+	m[result.Index] = *result
+	return nil
+}
+
+type BulkSendSummary struct {
+	Total     int // number of recipients
+	Skipped   int // already finished in the previous runs
+	Succeeded int
+	Failed    int // failed with user-side or other non-retryable errors
+	Pending   int // failed with retryable errors, or not sent because of cancellation
+}
+
+// BulkTemplateSender sends template messages to many recipients with rate limiting and retries.
+type BulkTemplateSender struct {
+	client *Client
+	store  BulkSendStore
+
+	QPS          int           // maximum sending rate, default 20
+	Workers      int           // number of concurrent senders, default 4
+	MaxRetries   int           // retries of retryable errors for each recipient, default 3
+	RetryBackoff time.Duration // backoff of the first retry, doubled for each next retry, default 1s
+
+	// RetryTransportErrors makes the errors without errcode (e.g. network errors and timeouts) retryable.
+	// They are failed by default, as the message may have been delivered, and retrying may send it twice.
+	RetryTransportErrors bool
+
+	OnResult func(result *BulkSendResult) // called for each recipient
+}
+
+func NewBulkTemplateSender(client *Client, store ...BulkSendStore) *BulkTemplateSender {
+	s := &BulkTemplateSender{
+		client:       client,
+		QPS:          20,
+		Workers:      4,
+		MaxRetries:   3,
+		RetryBackoff: time.Second,
+	}
+	if len(store) > 0 {
+		s.store = store[0]
+	} else {
+		s.store = NewMemoryBulkSendStore()
+	}
+	return s
+}
+
+// Send runs the job until all recipients are finished or ctx is done.
+// Recipients finished in the previous runs of the same job ID are skipped.
+func (s *BulkTemplateSender) Send(ctx context.Context, job *BulkTemplateJob) (*BulkSendSummary, error) {
+	results, err := s.store.LoadResults(job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	finished := make(map[int]bool, len(results))
+	for _, r := range results {
+		if !r.Retryable {
+			finished[r.Index] = true
+		}
+	}
+
+	summary := &BulkSendSummary{Total: len(job.Recipients)}
+
+	qps := s.QPS
+	if qps <= 0 {
+		qps = 20
+	}
+	ticker := time.NewTicker(time.Second / time.Duration(qps))
+	defer ticker.Stop()
+
+	workers := s.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	var mutex sync.Mutex
+	var storeErr error
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				result := s.send(ctx, ticker.C, job, index)
+
+				err := s.store.SaveResult(job.ID, result)
+
+				mutex.Lock()
+				switch {
+				case result.Succeeded():
+					summary.Succeeded++
+				case result.Retryable:
+					summary.Pending++
+				default:
+					summary.Failed++
+				}
+				if err != nil && storeErr == nil {
+					storeErr = err
+				}
+				mutex.Unlock()
+
+				if s.OnResult != nil {
+					s.OnResult(result)
+				}
+			}
+		}()
+	}
+
+LOOP:
+	for i := range job.Recipients {
+		if finished[i] {
+			summary.Skipped++
+			continue
+		}
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			mutex.Lock()
+			for j := i; j < len(job.Recipients); j++ {
+				if finished[j] {
+					summary.Skipped++
+				} else {
+					summary.Pending++
+				}
+			}
+			mutex.Unlock()
+			break LOOP
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if storeErr != nil {
+		return summary, storeErr
+	}
+	return summary, ctx.Err()
+}
+
+func (s *BulkTemplateSender) send(ctx context.Context, tick <-chan time.Time, job *BulkTemplateJob, index int) *BulkSendResult {
+	recipient := &job.Recipients[index]
+	result := &BulkSendResult{
+		Index:  index,
+		ToUser: recipient.ToUser,
+	}
+
+	backoff := s.RetryBackoff
+	for {
+		select {
+		case <-tick:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil { // checked after tick too, as select picks randomly
+			result.Retryable = true
+			result.ErrMsg = err.Error()
+			result.Time = time.Now()
+			return result
+		}
+
+		result.Attempts++
+		msgID, err := s.client.SendTemplateMessage(job.message(recipient))
+		result.Time = time.Now()
+		if err == nil {
+			result.MsgID = msgID
+			result.ErrCode, result.ErrMsg, result.Retryable = OK, "", false
+			return result
+		}
+
+		result.ErrCode, result.ErrMsg = errCodeMsg(err)
+		result.Retryable = isRetryableSendErr(err, s.RetryTransportErrors)
+		if !result.Retryable || result.Attempts > s.MaxRetries {
+			return result
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return result
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,114 @@
+package mp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBulkTemplateSender(t *testing.T) {
+	var mutex sync.Mutex
+	responses := map[string][]string{} // by touser, the responses of each attempt, then OK
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var msg TemplateMsg
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &msg)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if reps := responses[msg.ToUser]; len(reps) > 0 {
+			responses[msg.ToUser] = reps[1:]
+			w.Write([]byte(reps[0]))
+			return
+		}
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok","msgid":1}`))
+	})
+
+	const (
+		busy         = `{"errcode":-1,"errmsg":"system error"}`
+		quota        = `{"errcode":45009,"errmsg":"reach max api daily quota limit"}`
+		unsubscribed = `{"errcode":43004,"errmsg":"require subscribe"}`
+	)
+	tests := []struct {
+		name      string
+		responses []string
+		errCode   int
+		attempts  int
+		retryable bool
+	}{
+		{name: "sent", attempts: 1},
+		{name: "busy retried", responses: []string{busy}, attempts: 2},
+		{name: "quota retried", responses: []string{quota, quota}, attempts: 3},
+		{name: "retries exhausted", responses: []string{quota, quota, quota, quota}, errCode: APIQuotaExceeded, attempts: 4, retryable: true},
+		{name: "user error not retried", responses: []string{unsubscribed}, errCode: UserUnsubscribed, attempts: 1},
+	}
+
+	job := &BulkTemplateJob{ID: "job", Template: TemplateMsg{TemplateID: "template"}}
+	for i, tt := range tests {
+		toUser := fmt.Sprintf("user%d", i)
+		job.Recipients = append(job.Recipients, BulkTemplateRecipient{ToUser: toUser})
+		responses[toUser] = tt.responses
+	}
+
+	results := make(map[string]BulkSendResult)
+	sender := NewBulkTemplateSender(c)
+	sender.QPS = 1000
+	sender.RetryBackoff = time.Millisecond
+	sender.OnResult = func(result *BulkSendResult) {
+		mutex.Lock()
+		results[result.ToUser] = *result
+		mutex.Unlock()
+	}
+
+	summary, err := sender.Send(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BulkSendSummary{Total: 5, Succeeded: 3, Failed: 1, Pending: 1}
+	if *summary != want {
+		t.Errorf("summary = %+v, want %+v", *summary, want)
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := results[fmt.Sprintf("user%d", i)]
+			if result.ErrCode != tt.errCode || result.Attempts != tt.attempts || result.Retryable != tt.retryable {
+				t.Errorf("result = %+v", result)
+			}
+		})
+	}
+
+	// resumed, only the pending recipient is sent again
+	summary, err = sender.Send(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = BulkSendSummary{Total: 5, Skipped: 4, Succeeded: 1}
+	if *summary != want {
+		t.Errorf("resumed summary = %+v, want %+v", *summary, want)
+	}
+}
+
+func TestBulkTemplateSenderCanceled(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok","msgid":1}`))
+	})
+	job := &BulkTemplateJob{ID: "job", Template: TemplateMsg{TemplateID: "template"}}
+	for i := 0; i < 5; i++ {
+		job.Recipients = append(job.Recipients, BulkTemplateRecipient{ToUser: fmt.Sprintf("user%d", i)})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	summary, err := NewBulkTemplateSender(c).Send(ctx, job)
+	if err != context.Canceled {
+		t.Fatalf("err = %v, want canceled", err)
+	}
+	if summary.Succeeded != 0 || summary.Pending != 5 {
+		t.Errorf("summary = %+v, nothing should be sent", *summary)
+	}
+}
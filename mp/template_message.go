@@ -1,5 +1,11 @@
 package mp
 
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
 type TemplateMsgValue struct {
 	Value string `json:"value"`
 	Color string `json:"color,omitempty"`
@@ -38,3 +44,159 @@ func (c *Client) SendTemplateMessage(msg *TemplateMsg, values ...map[string]stri
 	msgID = rep.MsgID
 	return
 }
+
+type Industry struct {
+	FirstClass  string `json:"first_class"`
+	SecondClass string `json:"second_class"`
+}
+
+// SetIndustry sets the primary and secondary industries of the account by industry codes.
+func (c *Client) SetIndustry(primaryID, secondaryID string) error {
+	u := BASE_URL.Join("/template/api_set_industry")
+
+	var req = struct {
+		PrimaryID   string `json:"industry_id1"`
+		SecondaryID string `json:"industry_id2"`
+	}{
+		PrimaryID:   primaryID,
+		SecondaryID: secondaryID,
+	}
+
+	var rep Err
+	return c.Post(u, &req, &rep)
+}
+
+func (c *Client) GetIndustry() (primary, secondary *Industry, err error) {
+	u := BASE_URL.Join("/template/get_industry")
+
+	var rep struct {
+		Err
+		Primary   Industry `json:"primary_industry"`
+		Secondary Industry `json:"secondary_industry"`
+	}
+
+	err = c.Get(u, &rep)
+	if err != nil {
+		return
+	}
+
+	return &rep.Primary, &rep.Secondary, nil
+}
+
+// AddTemplate adds the template of the short ID in the template library to the account.
+func (c *Client) AddTemplate(shortID string) (templateID string, err error) {
+	u := BASE_URL.Join("/template/api_add_template")
+
+	var req = struct {
+		ShortID string `json:"template_id_short"`
+	}{
+		ShortID: shortID,
+	}
+
+	var rep struct {
+		Err
+		TemplateID string `json:"template_id"`
+	}
+
+	err = c.Post(u, &req, &rep)
+	if err != nil {
+		return
+	}
+
+	templateID = rep.TemplateID
+	return
+}
+
+type Template struct {
+	ID              string `json:"template_id"`
+	Title           string `json:"title"`
+	PrimaryIndustry string `json:"primary_industry"`
+	DeputyIndustry  string `json:"deputy_industry"`
+	Content         string `json:"content"` // e.g. "{{first.DATA}}\n订单号：{{keyword1.DATA}}\n{{remark.DATA}}"
+	Example         string `json:"example"`
+}
+
+func (c *Client) GetTemplates() ([]Template, error) {
+	u := BASE_URL.Join("/template/get_all_private_template")
+
+	var rep struct {
+		Err
+		Templates []Template `json:"template_list"`
+	}
+
+	err := c.Get(u, &rep)
+	if err != nil {
+		return nil, err
+	}
+
+	return rep.Templates, nil
+}
+
+func (c *Client) DeleteTemplate(templateID string) error {
+	u := BASE_URL.Join("/template/del_private_template")
+
+	var req = struct {
+		TemplateID string `json:"template_id"`
+	}{
+		TemplateID: templateID,
+	}
+
+	var rep Err
+	return c.Post(u, &req, &rep)
+}
+
+var templateKeyRegexp = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\.DATA\s*\}\}`)
+
+// ParseTemplateKeys returns the data keys of the placeholders in the template content, in order of appearance.
+func ParseTemplateKeys(content string) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, match := range templateKeyRegexp.FindAllStringSubmatch(content, -1) {
+		key := match[1]
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (t *Template) Keys() []string {
+	return ParseTemplateKeys(t.Content)
+}
+
+// Validate checks that the data of the message match the placeholders of the template exactly.
+func (t *Template) Validate(msg *TemplateMsg) error {
+	if msg.TemplateID != t.ID {
+		return fmt.Errorf("template id mismatch: %s, %s", msg.TemplateID, t.ID)
+	}
+
+	keys := t.Keys()
+
+	var missing, unknown []string
+	for _, key := range keys {
+		if _, ok := msg.Data[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	for key := range msg.Data {
+		if !contains(keys, key) {
+			unknown = append(unknown, key)
+		}
+	}
+
+	if len(missing) == 0 && len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("template %s data mismatch: missing keys %v, unknown keys %v", t.ID, missing, unknown)
+}
+
+func contains(slice []string, s string) bool {
+	for _, e := range slice {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
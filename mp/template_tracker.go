@@ -0,0 +1,161 @@
+package mp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jiudaoyun/wechat"
+	"go.uber.org/zap"
+)
+
+// Delivery status of template messages.
+// Except TemplateMsgSent, they are the Status of the TEMPLATESENDJOBFINISH event.
+const (
+	TemplateMsgSent         = "sent" // sent, waiting for the job finish event
+	TemplateMsgSuccess      = "success"
+	TemplateMsgUserBlock    = "failed:user block"
+	TemplateMsgSystemFailed = "failed: system failed"
+)
+
+type TemplateMsgRecord struct {
+	MsgID      int64     `json:"msgid"`
+	ToUser     string    `json:"touser"`
+	TemplateID string    `json:"template_id,omitempty"`
+	Status     string    `json:"status"`
+	SentAt     time.Time `json:"sent_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+func (r *TemplateMsgRecord) IsFinished() bool {
+	return r.Status != "" && r.Status != TemplateMsgSent
+}
+
+// TemplateMsgStore persists the delivery status of template messages.
+type TemplateMsgStore interface {
+	Get(msgID int64) (*TemplateMsgRecord, error) // returns nil record if not found
+	Put(record *TemplateMsgRecord) error
+}
+
+type MemoryTemplateMsgStore struct {
+	mutex   sync.RWMutex
+	records map[int64]TemplateMsgRecord
+}
+
+func NewMemoryTemplateMsgStore() *MemoryTemplateMsgStore {
+	return &MemoryTemplateMsgStore{
+		records: make(map[int64]TemplateMsgRecord),
+	}
+}
+
+func (s *MemoryTemplateMsgStore) Get(msgID int64) (*TemplateMsgRecord, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	record, ok := s.records[msgID]
+	if !ok {
+		return nil, nil
+	}
+	return &record, nil
+}
+
+func (s *MemoryTemplateMsgStore) Put(record *TemplateMsgRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.records[record.MsgID] = *record
+	return nil
+}
+
+// TemplateMsgTracker sends template messages and correlates the TEMPLATESENDJOBFINISH
+// events back to them. Register HandleJobFinish on the server:
+//
+//	srv.HandleEvent(mp.EventTemplateSendJobFinish, tracker.HandleJobFinish)
+type TemplateMsgTracker struct {
+	client *Client
+	store  TemplateMsgStore
+	logger *zap.SugaredLogger
+	mutex  sync.Mutex
+
+	OnFinish func(record *TemplateMsgRecord) // called when a job finish event arrives
+}
+
+func NewTemplateMsgTracker(client *Client, store ...TemplateMsgStore) *TemplateMsgTracker {
+	t := &TemplateMsgTracker{
+		client: client,
+		logger: wechat.Sugar,
+	}
+	if len(store) > 0 {
+		t.store = store[0]
+	} else {
+		t.store = NewMemoryTemplateMsgStore()
+	}
+	return t
+}
+
+func (t *TemplateMsgTracker) Send(msg *TemplateMsg, values ...map[string]string) (msgID int64, err error) {
+	msgID, err = t.client.SendTemplateMessage(msg, values...)
+	if err != nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	record, err := t.store.Get(msgID)
+	if err != nil {
+		return
+	}
+	if record == nil {
+		record = &TemplateMsgRecord{
+			MsgID:  msgID,
+			Status: TemplateMsgSent,
+		}
+	}
+	// the job finish event may arrive before here
+	record.ToUser = msg.ToUser
+	record.TemplateID = msg.TemplateID
+	record.SentAt = time.Now()
+
+	err = t.store.Put(record)
+	return
+}
+
+// Status returns the delivery record of the message, or nil if unknown.
+func (t *TemplateMsgTracker) Status(msgID int64) (*TemplateMsgRecord, error) {
+	return t.store.Get(msgID)
+}
+
+func (t *TemplateMsgTracker) HandleJobFinish(ctx *Context) {
+	record, err := t.finish(ctx.Event)
+	if err != nil {
+		t.logger.Errorw("Store template message status failed", "msgid", ctx.MsgId, "status", ctx.Status, "error", err)
+		return
+	}
+
+	if t.OnFinish != nil {
+		t.OnFinish(record)
+	}
+}
+
+func (t *TemplateMsgTracker) finish(event *Event) (*TemplateMsgRecord, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	record, err := t.store.Get(event.MsgId)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		record = &TemplateMsgRecord{
+			MsgID:  event.MsgId,
+			ToUser: event.FromUser,
+		}
+	}
+	record.Status = event.Status
+	record.FinishedAt = time.Unix(event.CreatedTime, 0)
+
+	if err = t.store.Put(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
@@ -0,0 +1,54 @@
+package mp
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// failingTemplateMsgStore fails to put any record.
+type failingTemplateMsgStore struct {
+	*MemoryTemplateMsgStore
+}
+
+func (s failingTemplateMsgStore) Put(record *TemplateMsgRecord) error {
+	return errors.New("store unavailable")
+}
+
+func TestTemplateMsgTrackerHandleJobFinish(t *testing.T) {
+	tests := []struct {
+		name       string
+		store      TemplateMsgStore
+		wantFinish bool
+		wantLogs   int
+	}{
+		{name: "finished", store: NewMemoryTemplateMsgStore(), wantFinish: true},
+		{name: "store failed", store: failingTemplateMsgStore{NewMemoryTemplateMsgStore()}, wantLogs: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.ErrorLevel)
+			tracker := NewTemplateMsgTracker(nil, tt.store)
+			tracker.logger = zap.New(core).Sugar()
+
+			var finished *TemplateMsgRecord
+			tracker.OnFinish = func(record *TemplateMsgRecord) {
+				finished = record
+			}
+			tracker.HandleJobFinish(&Context{Event: &Event{MsgId: 1000, Status: TemplateMsgSuccess}})
+
+			if (finished != nil) != tt.wantFinish {
+				t.Fatalf("finished = %+v, want %v", finished, tt.wantFinish)
+			}
+			if finished != nil && (finished.MsgID != 1000 || finished.Status != TemplateMsgSuccess) {
+				t.Errorf("finished = %+v", finished)
+			}
+			if n := logs.Len(); n != tt.wantLogs {
+				t.Errorf("logged %d errors, want %d", n, tt.wantLogs)
+			}
+		})
+	}
+}
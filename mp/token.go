@@ -1,189 +1,460 @@
 package mp
 
 import (
-	"fmt"
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 const (
-	wechatTokenUrl   = "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=%s&secret=%s"
-	wechatTicketUrl  = "https://api.weixin.qq.com/cgi-bin/ticket/getticket?access_token=%s&type=jsapi"
+	wechatTokenUrl       = "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=%s&secret=%s"
+	wechatTicketUrl      = "https://api.weixin.qq.com/cgi-bin/ticket/getticket?access_token=%s&type=%s"
 	wechatCorpTokenUrl   = "https://qyapi.weixin.qq.com/cgi-bin/gettoken?corpid=%s&corpsecret=%s"
-	validityDuration = time.Duration(7200) * time.Second
+	wechatStableTokenUrl = "https://api.weixin.qq.com/cgi-bin/stable_token"
+	validityDuration     = time.Duration(7200) * time.Second
+
+	refreshAheadRatio = 0.2  // refresh when 20% of the validity remains
+	jitterRatio       = 0.05 // refresh earlier randomly by up to 5% of the validity, so instances do not refresh together
+	minRetryDelay     = time.Second
+	maxRetryDelay     = time.Minute
+)
+
+// Ticket types
+const (
+	TicketJSAPI = "jsapi"   // for JS-SDK
+	TicketCard  = "wx_card" // for card APIs of JS-SDK
 )
 
-type refreshData struct {
-	token string
-	ticket string
+// resource is the access token or a ticket, refreshed independently.
+type resource struct {
+	value atomic.Value // *resourceValue
+
+	mutex     sync.Mutex
+	refreshAt time.Time    // when to refresh ahead, zero if never fetched
+	failures  int          // consecutive failures of refreshing
+	call      *refreshCall // the refresh in flight
 }
 
-type refreshDataResult struct {
-	refreshData
+type resourceValue struct {
+	value     string
+	expiresAt time.Time
+}
+
+// refreshCall is a refresh in flight, shared by the concurrent callers.
+type refreshCall struct {
+	done  chan struct{}
+	value string
 	err   error
 }
 
+// load returns the current value, which is empty if not fetched or expired.
+// The value is still served while it is being refreshed ahead of expiry.
+func (r *resource) load() string {
+	v, _ := r.value.Load().(*resourceValue)
+	if v == nil || !time.Now().Before(v.expiresAt) {
+		return ""
+	}
+	return v.value
+}
+
+// store must be called with mutex held.
+// expiresIn has been shortened by a margin in parseTokenResponse.
+func (r *resource) store(value string, expiresIn int64) {
+	lifetime := time.Duration(expiresIn) * time.Second
+	unchanged := value == r.load()
+	r.value.Store(&resourceValue{
+		value:     value,
+		expiresAt: time.Now().Add(lifetime),
+	})
+
+	if unchanged {
+		// stable_token returns the same token until the last minutes of it, so refreshing ahead
+		// would get the same one again and again, refresh when it expires instead.
+		r.refreshAt = time.Now().Add(lifetime)
+		r.failures = 0
+		return
+	}
+
+	ahead := time.Duration(float64(lifetime) * (refreshAheadRatio + jitterRatio*rand.Float64()))
+	if lifetime-ahead < minRetryDelay {
+		ahead = lifetime - minRetryDelay
+	}
+	r.refreshAt = time.Now().Add(lifetime - ahead)
+	r.failures = 0
+}
+
+// backoff schedules the retry of the failed refresh with exponential backoff.
+// Only the fetched resources are scheduled, others are fetched on demand.
+// It must be called with mutex held.
+func (r *resource) backoff() {
+	if r.refreshAt.IsZero() {
+		return
+	}
+
+	delay := maxRetryDelay
+	if r.failures < 16 {
+		if d := minRetryDelay << uint(r.failures); d < delay {
+			delay = d
+		}
+	}
+	r.failures++
+	r.refreshAt = time.Now().Add(delay)
+}
+
+// refresh fetches the value, unless used is not empty and differs from the current value,
+// which means it has been refreshed by others. The concurrent refreshes are coalesced into one fetch.
+func (r *resource) refresh(used string, fetch func(force bool) (string, int64, error)) (string, error) {
+	r.mutex.Lock()
+	current := r.load()
+	if used != "" && current != "" && used != current {
+		r.mutex.Unlock()
+		return current, nil
+	}
+	if call := r.call; call != nil {
+		r.mutex.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	r.call = call
+	r.mutex.Unlock()
+
+	// the current value is rejected, force a new one
+	value, expiresIn, err := fetch(used != "" && used == current)
+
+	r.mutex.Lock()
+	if err != nil {
+		r.backoff()
+	} else {
+		r.store(value, expiresIn)
+	}
+	r.call = nil
+	r.mutex.Unlock()
+
+	call.value, call.err = value, err
+	close(call.done)
+	return value, err
+}
+
+func (r *resource) dueAt() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.refreshAt
+}
+
+// TokenAccessor gets the access token and the tickets. They are fetched on first use,
+// and refreshed when expired or rejected. Start refreshes them ahead of expiry in background.
 type TokenAccessor struct {
 	appID     string
 	appSecret string
 
-	needsTicket bool
+	url    string
+	stable bool // whether to use stable_token
+
+	observer atomic.Value // observerBox
 
-	url       string
+	token resource
 
-	refreshReq chan refreshData
-	refreshRep chan refreshDataResult
-	done       chan struct{}
+	ticketsMutex sync.Mutex
+	tickets      map[string]*resource
 
-	token  atomic.Value
-	ticket atomic.Value
+	runMutex sync.Mutex
+	cancel   context.CancelFunc // cancels the running Start
+	stopped  chan struct{}      // closed when the running Start exits
+	wake     chan struct{}      // wakes the running Start to reschedule
 }
 
+// NewTokenAccessor creates the accessor of the app. needsTicket is ignored and only kept for compatibility,
+// as the tickets are fetched on first use.
 func NewTokenAccessor(appId, appSecret string, needsTicket bool) (ta *TokenAccessor) {
 	ta = &TokenAccessor{
-		appID:       url.QueryEscape(appId),
-		appSecret:   url.QueryEscape(appSecret),
-		needsTicket: needsTicket,
-		refreshReq:  make(chan refreshData),
-		refreshRep:  make(chan refreshDataResult),
-		done:        make(chan struct{}),
-	}
-	ta.url = fmt.Sprintf(wechatTokenUrl, ta.appID, ta.appSecret)
+		appID:     appId,
+		appSecret: appSecret,
+		tickets:   make(map[string]*resource),
+		wake:      make(chan struct{}, 1),
+	}
+	ta.url = fmt.Sprintf(wechatTokenUrl, url.QueryEscape(appId), url.QueryEscape(appSecret))
 	return
 }
 
-
 func NewCorpTokenAccessor(corpID, corpSecret string) (ta *TokenAccessor) {
 	ta = NewTokenAccessor(corpID, corpSecret, false)
-	ta.url = fmt.Sprintf(wechatCorpTokenUrl, ta.appID, ta.appSecret)
+	ta.url = fmt.Sprintf(wechatCorpTokenUrl, url.QueryEscape(corpID), url.QueryEscape(corpSecret))
 	return
 }
 
+// UseStableToken makes the accessor fetch the token by the stable_token API, which does not invalidate the tokens
+// held by other systems sharing the same app. A refresh due to the token being rejected forces a new token,
+// which is limited by Wechat to a few times per day. It should be called before using the accessor.
+func (ta *TokenAccessor) UseStableToken() {
+	ta.stable = true
+}
+
+// Start refreshes the token and the tickets ahead of expiry in background, until Stop is called.
+// It does nothing if already started.
 func (ta *TokenAccessor) Start() {
-	go ta.updateTokenLoop()
+	ta.runMutex.Lock()
+	defer ta.runMutex.Unlock()
+
+	if ta.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ta.cancel = cancel
+	ta.stopped = make(chan struct{})
+	go func(stopped chan struct{}) {
+		defer close(stopped)
+		ta.Run(ctx)
+	}(ta.stopped)
 }
 
+// Stop stops the background refreshing started by Start, and waits for it to exit.
+// It does nothing if not started. The accessor is still usable after stopped.
 func (ta *TokenAccessor) Stop() {
-	ta.done <- struct{}{}
-	<-ta.done
+	ta.runMutex.Lock()
+	defer ta.runMutex.Unlock()
+
+	if ta.cancel == nil {
+		return
+	}
+
+	ta.cancel()
+	<-ta.stopped
+	ta.cancel, ta.stopped = nil, nil
+}
+
+// Run refreshes the token and the tickets ahead of expiry, until ctx is done.
+// Use Start and Stop instead, unless the lifetime is managed by a context.
+func (ta *TokenAccessor) Run(ctx context.Context) {
+	timer := time.NewTimer(ta.nextRefresh())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ta.wake:
+		case <-timer.C:
+			now := time.Now()
+			if at := ta.token.dueAt(); !at.IsZero() && !now.Before(at) {
+				ta.token.refresh("", ta.tokenFetcher(ctx))
+			}
+			for ticketType, r := range ta.allTickets() {
+				if at := r.dueAt(); !at.IsZero() && !now.Before(at) {
+					r.refresh("", ta.ticketFetcher(ctx, ticketType))
+				}
+			}
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(ta.nextRefresh())
+	}
 }
 
 func (ta *TokenAccessor) Token() (token string, err error) {
-	token, ok := ta.token.Load().(string)
-	if ok && token != "" {
+	token = ta.token.load()
+	if token != "" {
 		return token, nil
 	}
 
 	return ta.RefreshToken("")
 }
 
+// RefreshToken refreshes the access token. If usedToken is not empty and the current token is different,
+// which means it has been refreshed by others, the current token is returned without refreshing.
 func (ta *TokenAccessor) RefreshToken(usedToken string) (token string, err error) {
-	ta.refreshReq <- refreshData{token: usedToken}
-	rep := <-ta.refreshRep
-	return rep.token, rep.err
+	token, err = ta.token.refresh(usedToken, ta.tokenFetcher(context.Background()))
+	ta.notify()
+	return
 }
 
+// Ticket returns the jsapi ticket.
 func (ta *TokenAccessor) Ticket() (ticket string, err error) {
-	ticket, ok := ta.ticket.Load().(string)
-	if ok && ticket != "" {
+	return ta.TicketOf(TicketJSAPI)
+}
+
+// RefreshTicket refreshes the jsapi ticket, see RefreshToken for usedTicket.
+func (ta *TokenAccessor) RefreshTicket(usedTicket string) (ticket string, err error) {
+	return ta.RefreshTicketOf(TicketJSAPI, usedTicket)
+}
+
+// CardTicket returns the wx_card api_ticket.
+func (ta *TokenAccessor) CardTicket() (ticket string, err error) {
+	return ta.TicketOf(TicketCard)
+}
+
+// RefreshCardTicket refreshes the wx_card api_ticket, see RefreshToken for usedTicket.
+func (ta *TokenAccessor) RefreshCardTicket(usedTicket string) (ticket string, err error) {
+	return ta.RefreshTicketOf(TicketCard, usedTicket)
+}
+
+// TicketOf returns the ticket of ticketType, which is fetched on first use and refreshed independently of the token.
+func (ta *TokenAccessor) TicketOf(ticketType string) (ticket string, err error) {
+	ticket = ta.ticket(ticketType).load()
+	if ticket != "" {
 		return ticket, nil
 	}
 
-	return ta.RefreshTicket("")
+	return ta.RefreshTicketOf(ticketType, "")
 }
 
-func (ta *TokenAccessor) RefreshTicket(usedTicket string) (ticket string, err error) {
-	ta.refreshReq <- refreshData{ticket: usedTicket}
-	rep := <-ta.refreshRep
-	return rep.ticket, rep.err
+func (ta *TokenAccessor) RefreshTicketOf(ticketType, usedTicket string) (ticket string, err error) {
+	ticket, err = ta.ticket(ticketType).refresh(usedTicket, ta.ticketFetcher(context.Background(), ticketType))
+	ta.notify()
+	return
 }
 
-func (ta *TokenAccessor) updateTokenLoop() {
-	tickDuration := validityDuration
-	ticker := time.NewTicker(tickDuration)
+func (ta *TokenAccessor) ticket(ticketType string) *resource {
+	ta.ticketsMutex.Lock()
+	defer ta.ticketsMutex.Unlock()
 
-LOOP:
-	for {
-		select {
-		case <-ticker.C:
-			_, _, expiresIn, err := ta.updateToken()
-			if err != nil {
-				break
-			}
-			newTickDuration := time.Duration(expiresIn) * time.Second
-			if newTickDuration < tickDuration || newTickDuration-tickDuration > 5*time.Second {
-				tickDuration = newTickDuration
-				ticker.Stop()
-				ticker = time.NewTicker(tickDuration)
-			}
+	r, ok := ta.tickets[ticketType]
+	if !ok {
+		r = &resource{}
+		ta.tickets[ticketType] = r
+	}
+	return r
+}
 
-		case req := <-ta.refreshReq:
-			if req.token != "" {
-				token, ok := ta.token.Load().(string)
-				if ok && token != "" && req.token != token {
-					ta.refreshRep <- refreshDataResult{refreshData: refreshData{token: token}}
-					break
-				}
-			}
-			if req.ticket != "" {
-				ticket, ok := ta.token.Load().(string)
-				if ok && ticket != "" && req.ticket != ticket {
-					ta.refreshRep <- refreshDataResult{refreshData: refreshData{ticket: ticket}}
-					break
-				}
-			}
-			token, ticket, expiresIn, err := ta.updateToken()
-			if err != nil {
-				ta.refreshRep <- refreshDataResult{err: err}
-				break
-			}
+func (ta *TokenAccessor) allTickets() map[string]*resource {
+	ta.ticketsMutex.Lock()
+	defer ta.ticketsMutex.Unlock()
+
+	tickets := make(map[string]*resource, len(ta.tickets))
+	for ticketType, r := range ta.tickets {
+		tickets[ticketType] = r
+	}
+	return tickets
+}
+
+// notify wakes Run to reschedule, as the refresh time may be changed.
+func (ta *TokenAccessor) notify() {
+	select {
+	case ta.wake <- struct{}{}:
+	default:
+	}
+}
 
-			ta.refreshRep <- refreshDataResult{refreshData: refreshData{token, ticket}}
+// nextRefresh returns the duration until the earliest scheduled refresh.
+func (ta *TokenAccessor) nextRefresh() time.Duration {
+	next := ta.token.dueAt()
+	for _, r := range ta.allTickets() {
+		if at := r.dueAt(); next.IsZero() || (!at.IsZero() && at.Before(next)) {
+			next = at
+		}
+	}
+	if next.IsZero() {
+		return validityDuration
+	}
+	if d := time.Until(next); d > 0 {
+		return d
+	}
+	return 0
+}
 
-			tickDuration = time.Duration(expiresIn) * time.Second
-			ticker.Stop()
-			ticker = time.NewTicker(tickDuration)
+// observed notifies the observer of the fetch of the resource name.
+func (ta *TokenAccessor) observed(name string, fetch func(force bool) (string, int64, error)) func(force bool) (string, int64, error) {
+	return func(force bool) (value string, expiresIn int64, err error) {
+		o := ta.getObserver()
+		if force {
+			o.ForcedRefresh(name)
+		}
 
-		case <-ta.done:
-			break LOOP
+		value, expiresIn, err = fetch(force)
+		if err != nil {
+			o.FetchFailed(name, err)
+		} else {
+			o.TokenFetched(name, time.Duration(expiresIn)*time.Second)
 		}
+		return
 	}
+}
 
-	ticker.Stop()
-	ta.done <- struct{}{}
+func (ta *TokenAccessor) tokenFetcher(ctx context.Context) func(force bool) (string, int64, error) {
+	return ta.observed(ObservedAccessToken, func(force bool) (string, int64, error) {
+		if ta.stable {
+			return ta.updateStable(ctx, force)
+		}
+		return ta.update(ctx, ta.url)
+	})
 }
 
-func (ta *TokenAccessor) updateToken() (token, ticket string, expiresIn int64, err error) {
-	defer ta.token.Store(token)
+func (ta *TokenAccessor) ticketFetcher(ctx context.Context, ticketType string) func(force bool) (string, int64, error) {
+	return ta.observed(ticketType, func(force bool) (ticket string, expiresIn int64, err error) {
+		token, err := ta.Token()
+		if err != nil {
+			return
+		}
+
+		ticket, expiresIn, err = ta.update(ctx, fmt.Sprintf(wechatTicketUrl, token, ticketType))
+		if e, ok := err.(Error); ok && (e.Code() == InvalidCredential || e.Code() == AccessTokenExpired) {
+			if token, err = ta.RefreshToken(token); err != nil {
+				return
+			}
+			ticket, expiresIn, err = ta.update(ctx, fmt.Sprintf(wechatTicketUrl, token, ticketType))
+		}
+		return
+	})
+}
 
-	token, expiresIn, err = ta.update(ta.url)
+func (ta *TokenAccessor) update(ctx context.Context, url string) (result string, expiresIn int64, err error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return
 	}
 
-	if !ta.needsTicket {
+	rep, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
 		return
 	}
+	return parseTokenResponse(rep)
+}
 
-	defer ta.ticket.Store(ticket)
+func (ta *TokenAccessor) updateStable(ctx context.Context, force bool) (result string, expiresIn int64, err error) {
+	var body = struct {
+		GrantType    string `json:"grant_type"`
+		AppID        string `json:"appid"`
+		Secret       string `json:"secret"`
+		ForceRefresh bool   `json:"force_refresh"`
+	}{
+		GrantType:    "client_credential",
+		AppID:        ta.appID,
+		Secret:       ta.appSecret,
+		ForceRefresh: force,
+	}
 
-	var ticketExpires int64
-	ticket, ticketExpires, err = ta.update(fmt.Sprintf(wechatTicketUrl, token))
-	if err == nil && ticketExpires < expiresIn {
-		expiresIn = ticketExpires
+	data, err := json.Marshal(&body)
+	if err != nil {
+		return
 	}
 
-	return
-}
+	req, err := http.NewRequest(http.MethodPost, wechatStableTokenUrl, bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
 
-func (ta *TokenAccessor) update(url string) (result string, expiresIn int64, err error) {
-	rep, err := http.Get(url)
+	rep, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return
 	}
+	return parseTokenResponse(rep)
+}
+
+func parseTokenResponse(rep *http.Response) (result string, expiresIn int64, err error) {
 	defer rep.Body.Close()
 
 	if rep.StatusCode != http.StatusOK {
@@ -193,7 +464,7 @@ func (ta *TokenAccessor) update(url string) (result string, expiresIn int64, err
 
 	var response struct {
 		AccessToken string `json:"access_token,omitempty"`
-		Ticket string `json:"ticket,omitempty"`
+		Ticket      string `json:"ticket,omitempty"`
 		ExpiresIn   int64  `json:"expires_in"`
 		Err
 	}
@@ -203,6 +474,7 @@ func (ta *TokenAccessor) update(url string) (result string, expiresIn int64, err
 	}
 
 	if response.Code() != OK {
+		response.parseRid()
 		err = &response.Err
 		return
 	}
@@ -219,6 +491,9 @@ func (ta *TokenAccessor) update(url string) (result string, expiresIn int64, err
 		response.ExpiresIn -= 60
 	case e > 60:
 		response.ExpiresIn -= 10
+	case e > 0:
+		// the stable token being about to expire, serve it for half of the time left
+		response.ExpiresIn = (e + 1) / 2
 	default:
 		err = fmt.Errorf("expires_in too small: %d", e)
 		return
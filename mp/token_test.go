@@ -0,0 +1,217 @@
+package mp
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResourceRefresh(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   string // stored before refreshing, empty if never fetched
+		used      string
+		callers   int
+		wantFetch int32
+		wantForce bool
+		want      string
+	}{
+		{name: "first fetch coalesced", callers: 10, wantFetch: 1, want: "new"},
+		{name: "refresh ahead coalesced", current: "old", callers: 10, wantFetch: 1, want: "new"},
+		{name: "rejected value forces fetch", current: "old", used: "old", callers: 10, wantFetch: 1, wantForce: true, want: "new"},
+		{name: "refreshed by others", current: "other", used: "old", callers: 3, wantFetch: 0, want: "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r resource
+			if tt.current != "" {
+				r.mutex.Lock()
+				r.store(tt.current, 7200)
+				r.mutex.Unlock()
+			}
+
+			var fetches int32
+			var forced atomic.Value
+			release := make(chan struct{})
+			fetch := func(force bool) (string, int64, error) {
+				atomic.AddInt32(&fetches, 1)
+				forced.Store(force)
+				<-release
+				return "new", 7200, nil
+			}
+
+			var wg sync.WaitGroup
+			values := make([]string, tt.callers)
+			for i := range values {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					values[i], _ = r.refresh(tt.used, fetch)
+				}(i)
+			}
+			time.Sleep(50 * time.Millisecond) // let the callers wait on the same fetch
+			close(release)
+			wg.Wait()
+
+			if fetches != tt.wantFetch {
+				t.Errorf("fetched %d times, want %d", fetches, tt.wantFetch)
+			}
+			if force, _ := forced.Load().(bool); force != tt.wantForce {
+				t.Errorf("force = %v, want %v", force, tt.wantForce)
+			}
+			for i, value := range values {
+				if value != tt.want {
+					t.Errorf("caller %d got %q, want %q", i, value, tt.want)
+				}
+			}
+			if value := r.load(); value != tt.want {
+				t.Errorf("stored %q, want %q", value, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceRefreshFailure(t *testing.T) {
+	var r resource
+	r.mutex.Lock()
+	r.store("old", 7200)
+	r.mutex.Unlock()
+
+	fetchErr := errors.New("fetch failed")
+	value, err := r.refresh("", func(bool) (string, int64, error) {
+		return "", 0, fetchErr
+	})
+	if err != fetchErr || value != "" {
+		t.Fatalf("got %q, %v", value, err)
+	}
+	if value = r.load(); value != "old" {
+		t.Errorf("the current value %q is not kept", value)
+	}
+	if r.failures != 1 {
+		t.Errorf("failures = %d, want 1", r.failures)
+	}
+
+	value, err = r.refresh("", func(bool) (string, int64, error) {
+		return "new", 7200, nil
+	})
+	if err != nil || value != "new" || r.failures != 0 {
+		t.Errorf("got %q, %v, failures %d", value, err, r.failures)
+	}
+}
+
+func TestResourceBackoff(t *testing.T) {
+	tests := []struct {
+		name      string
+		fetched   bool
+		failures  int
+		wantDelay time.Duration // zero if not scheduled
+	}{
+		{name: "never fetched", failures: 3},
+		{name: "first failure", fetched: true, wantDelay: minRetryDelay},
+		{name: "doubled", fetched: true, failures: 3, wantDelay: 8 * minRetryDelay},
+		{name: "capped", fetched: true, failures: 6, wantDelay: maxRetryDelay},
+		{name: "no overflow", fetched: true, failures: 100, wantDelay: maxRetryDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r resource
+			if tt.fetched {
+				r.store("value", 7200)
+			}
+			r.failures = tt.failures
+
+			start := time.Now()
+			r.backoff()
+
+			if tt.wantDelay == 0 {
+				if !r.refreshAt.IsZero() {
+					t.Fatalf("scheduled at %v, want not scheduled", r.refreshAt)
+				}
+				return
+			}
+			delay := r.refreshAt.Sub(start)
+			if delay < tt.wantDelay || delay > tt.wantDelay+time.Second {
+				t.Errorf("delay = %v, want %v", delay, tt.wantDelay)
+			}
+			if r.failures != tt.failures+1 {
+				t.Errorf("failures = %d, want %d", r.failures, tt.failures+1)
+			}
+		})
+	}
+}
+
+func TestResourceSchedule(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   string // stored before, empty if never fetched
+		value     string
+		expiresIn int64
+		wantMin   time.Duration // the range of the delay of the next refresh
+		wantMax   time.Duration
+	}{
+		{name: "first fetch refreshed ahead", value: "new", expiresIn: 7000, wantMin: 5250 * time.Second, wantMax: 5600 * time.Second},
+		{name: "new value refreshed ahead", current: "old", value: "new", expiresIn: 7000, wantMin: 5250 * time.Second, wantMax: 5600 * time.Second},
+		{name: "unchanged value refreshed on expiry", current: "same", value: "same", expiresIn: 1400, wantMin: 1400 * time.Second, wantMax: 1400 * time.Second},
+		{name: "short lifetime", current: "old", value: "new", expiresIn: 1, wantMin: minRetryDelay, wantMax: minRetryDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r resource
+			if tt.current != "" {
+				r.store(tt.current, 7000)
+			}
+			r.failures = 2
+
+			start := time.Now()
+			r.store(tt.value, tt.expiresIn)
+			delay := r.refreshAt.Sub(start)
+			if delay < tt.wantMin || delay > tt.wantMax+time.Second {
+				t.Errorf("delay = %v, want [%v, %v]", delay, tt.wantMin, tt.wantMax)
+			}
+			if r.failures != 0 {
+				t.Errorf("failures = %d, want 0", r.failures)
+			}
+		})
+	}
+}
+
+func TestParseTokenResponseMargin(t *testing.T) {
+	tests := []struct {
+		expiresIn int64
+		want      int64 // 0 if error
+	}{
+		{expiresIn: 7200, want: 6600},
+		{expiresIn: 1800, want: 1740},
+		{expiresIn: 120, want: 110},
+		{expiresIn: 40, want: 20},
+		{expiresIn: 1, want: 1},
+		{expiresIn: 0},
+		{expiresIn: 60 * 60 * 24 * 366},
+	}
+
+	for _, tt := range tests {
+		rep := &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"access_token":"token","expires_in":` + strconv.FormatInt(tt.expiresIn, 10) + `}`)),
+		}
+		token, expiresIn, err := parseTokenResponse(rep)
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("expires_in %d: got %d, want error", tt.expiresIn, expiresIn)
+			}
+			continue
+		}
+		if err != nil || token != "token" || expiresIn != tt.want {
+			t.Errorf("expires_in %d: got %q, %d, %v, want %d", tt.expiresIn, token, expiresIn, err, tt.want)
+		}
+	}
+}
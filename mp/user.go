@@ -273,3 +273,37 @@ func (c *Client) DeleteCroup(id string) error {
 	err := c.Post(u, &req, &rep)
 	return err
 }
+
+type UserIterator struct {
+	pager
+	nextId string
+	ids    []string
+}
+
+// IterateUsers iterates the OpenIDs of all subscribers, starting after nextId if given.
+// The page size is decided by the server, which is 10000 currently.
+func (c *Client) IterateUsers(nextId ...string) *UserIterator {
+	it := &UserIterator{}
+	if len(nextId) > 0 {
+		it.nextId = nextId[0]
+	}
+	it.pager = newPager(0, func(offset, count int) (int, bool, error) {
+		list, err := c.GetUserList(it.nextId)
+		if err != nil {
+			return 0, false, err
+		}
+		it.ids = list.Data.Ids
+		it.nextId = list.NextId
+		return len(list.Data.Ids), len(list.Data.Ids) > 0 && list.NextId != "", nil
+	})
+	return it
+}
+
+func (it *UserIterator) Value() string {
+	return it.ids[it.index]
+}
+
+// NextId returns the OpenID to continue the iteration from, after the current page.
+func (it *UserIterator) NextId() string {
+	return it.nextId
+}
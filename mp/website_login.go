@@ -0,0 +1,65 @@
+package mp
+
+import (
+	"net/http"
+)
+
+// Oauth2ScopeLogin is the scope of website login.
+const Oauth2ScopeLogin = "snsapi_login"
+
+// WebsiteApp is a website application of Wechat Open Platform, whose users log in by scanning QR code with Wechat.
+// It has its own app ID and secret, different from the official account.
+type WebsiteApp struct {
+	*http.Client
+
+	AppID     string
+	AppSecret string
+}
+
+func NewWebsiteApp(appID, appSecret string) *WebsiteApp {
+	return &WebsiteApp{
+		Client:    http.DefaultClient,
+		AppID:     appID,
+		AppSecret: appSecret,
+	}
+}
+
+// QRConnectURL returns the URL of the login page showing the QR code,
+// which redirects to redirectURI with code and state after the user confirms in Wechat.
+func (a *WebsiteApp) QRConnectURL(redirectURI, state string) string {
+	u := URL("https://open.weixin.qq.com/connect/qrconnect").
+		Query("appid", a.AppID).
+		Query("redirect_uri", redirectURI).
+		Query("response_type", "code").
+		Query("scope", Oauth2ScopeLogin).
+		Query("state", state)
+	return string(u) + "#wechat_redirect"
+}
+
+func (a *WebsiteApp) GetToken(code, state string) (*Oauth2Token, error) {
+	u := URL("https://api.weixin.qq.com/sns/oauth2/access_token").
+		Query("appid", a.AppID).
+		Query("secret", a.AppSecret).
+		Query("code", code).
+		Query("grant_type", "authorization_code")
+
+	return oauth2GetToken(a.Client, string(u), state)
+}
+
+func (a *WebsiteApp) RefreshToken(refreshToken string) (*Oauth2Token, error) {
+	u := URL("https://api.weixin.qq.com/sns/oauth2/refresh_token").
+		Query("appid", a.AppID).
+		Query("grant_type", "refresh_token").
+		Query("refresh_token", refreshToken)
+
+	return oauth2GetToken(a.Client, string(u), "")
+}
+
+func (a *WebsiteApp) GetUser(token, openID string) (*Oauth2User, error) {
+	return oauth2GetUser(a.Client, token, openID)
+}
+
+// CheckToken checks whether the user access token is still valid.
+func (a *WebsiteApp) CheckToken(token, openID string) (bool, error) {
+	return oauth2CheckToken(a.Client, token, openID)
+}